@@ -1,29 +1,54 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"reflect"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/oauth2"
 	"gopkg.in/yaml.v2"
 
 	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/audit"
+	"github.com/openshift/wisdom/pkg/cache"
+	"github.com/openshift/wisdom/pkg/conversation"
+	"github.com/openshift/wisdom/pkg/feedback"
+	"github.com/openshift/wisdom/pkg/filters/templates"
 	"github.com/openshift/wisdom/pkg/model"
-	hf "github.com/openshift/wisdom/pkg/model/huggingface"
-	"github.com/openshift/wisdom/pkg/model/ibm"
-	"github.com/openshift/wisdom/pkg/model/openai"
+	"github.com/openshift/wisdom/pkg/quota"
+	"github.com/openshift/wisdom/pkg/rag"
+	"github.com/openshift/wisdom/pkg/ratelimit"
 	"github.com/openshift/wisdom/pkg/server"
+	"github.com/openshift/wisdom/pkg/tracing"
+	"github.com/openshift/wisdom/pkg/usage"
+
+	// model providers register themselves with the model package
+	_ "github.com/openshift/wisdom/pkg/model/huggingface"
+	_ "github.com/openshift/wisdom/pkg/model/ibm"
+	_ "github.com/openshift/wisdom/pkg/model/ollama"
+	_ "github.com/openshift/wisdom/pkg/model/openai"
 )
 
 var (
-	models map[string]api.Model
+	models    map[string]api.Model
+	fallbacks map[string][]string
 )
 
 func main() {
@@ -35,6 +60,8 @@ func main() {
 
 	rootCmd.AddCommand(newStartServerCommand())
 	rootCmd.AddCommand(newInferCommand())
+	rootCmd.AddCommand(newUsageCommand())
+	rootCmd.AddCommand(newIndexCommand())
 	rootCmd.Execute()
 
 }
@@ -46,9 +73,25 @@ type options struct {
 
 type inferOptions struct {
 	options
-	provider string
-	modelId  string
-	prompt   string
+	provider  string
+	modelId   string
+	prompt    string
+	template  string
+	variables map[string]string
+}
+
+type usageOptions struct {
+	options
+	from  string
+	to    string
+	user  string
+	model string
+}
+
+type indexOptions struct {
+	options
+	dir    string
+	output string
 }
 
 func loadConfig(filename string) (api.Config, error) {
@@ -87,15 +130,95 @@ func newStartServerCommand() *cobra.Command {
 			}
 			r := mux.NewRouter()
 
-			models = initModels(config)
+			shutdownTracing, err := initTracing(config.ServerConfig.Tracing)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				// flush the spans still buffered by the exporter
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := shutdownTracing(ctx); err != nil {
+					log.WithError(err).Error("error shutting down tracing")
+				}
+			}()
+
+			models, fallbacks, err = initModels(config)
+			if err != nil {
+				return err
+			}
 
 			h := server.Handler{
 				DefaultProvider: config.DefaultProvider,
 				DefaultModel:    config.DefaultModelId,
 				Models:          models,
+				Fallbacks:       fallbacks,
 				ClientID:        config.ServerConfig.ClientID,
 				ClientSecret:    config.ServerConfig.ClientSecret,
 				AllowedUsers:    config.ServerConfig.AllowedUsers,
+				AdminUsers:      config.ServerConfig.AdminUsers,
+				Coalescer:       model.NewCoalescer(),
+			}
+			if config.ServerConfig.UsageLedgerFile != "" {
+				pricing := make(map[string]api.PricingConfig)
+				for _, m := range config.Models {
+					pricing[m.Provider+"/"+m.ModelId] = m.Pricing
+				}
+				h.UsageLedger, err = usage.NewLedger(config.ServerConfig.UsageLedgerFile, pricing)
+				if err != nil {
+					return err
+				}
+				defer h.UsageLedger.Close()
+			}
+			h.RateLimiter = ratelimit.NewLimiter(config.ServerConfig.RateLimit)
+			h.Quotas = quota.NewTracker(config.ServerConfig.Quota)
+			if h.UsageLedger != nil {
+				// restore this month's usage so restarts do not reset quotas
+				filter, err := usage.ParseDateRange("", "")
+				if err != nil {
+					return err
+				}
+				err = h.UsageLedger.Scan(filter, func(record usage.Record) {
+					h.Quotas.Debit(record.User, record.Model, record.TotalTokens, record.Time)
+				})
+				if err != nil {
+					return fmt.Errorf("error restoring quota usage from the usage ledger: %v", err)
+				}
+			} else if len(config.ServerConfig.Quota.Users) > 0 || config.ServerConfig.Quota.Default.Daily > 0 || config.ServerConfig.Quota.Default.Monthly > 0 {
+				log.Warn("No usageLedgerFile configured, quota usage will be reset when the server restarts")
+			}
+			if config.ServerConfig.Cache.MaxEntries > 0 {
+				responseCache, err := cache.NewCache(config.ServerConfig.Cache)
+				if err != nil {
+					return fmt.Errorf("error initializing the response cache: %v", err)
+				}
+				defer responseCache.Close()
+				h.Cache = responseCache
+			}
+			if config.ServerConfig.AuditLog.File != "" {
+				h.AuditLog, err = audit.NewLogger(config.ServerConfig.AuditLog)
+				if err != nil {
+					return err
+				}
+				defer h.AuditLog.Close()
+			}
+			h.Responses = feedback.NewResponses(config.ServerConfig.Feedback.MaxResponses)
+			if config.ServerConfig.Feedback.File != "" {
+				store, err := feedback.NewFileStore(config.ServerConfig.Feedback.File)
+				if err != nil {
+					return err
+				}
+				defer store.Close()
+				h.FeedbackStore = store
+			} else {
+				h.FeedbackStore = feedback.NewMemoryStore()
+			}
+			if config.ServerConfig.Conversations.Enabled {
+				h.Conversations, err = conversation.NewStore(config.ServerConfig.Conversations)
+				if err != nil {
+					return fmt.Errorf("error loading conversations: %v", err)
+				}
+				defer h.Conversations.Close()
 			}
 			tokenKey, err := base64.StdEncoding.DecodeString(config.ServerConfig.TokenEncryptionKey)
 			if err != nil {
@@ -133,7 +256,22 @@ func newStartServerCommand() *cobra.Command {
 
 			r.HandleFunc("/infer", h.InferHandler).Methods("POST")
 			r.HandleFunc("/infer", h.CORSHandler).Methods("OPTIONS")
-			//r.HandleFunc("/feedback", h.FeedbackHandler).Methods("POST")
+			r.HandleFunc("/infer/stream", h.InferStreamHandler).Methods("POST")
+			r.HandleFunc("/infer/stream", h.CORSHandler).Methods("OPTIONS")
+			r.HandleFunc("/feedback", h.FeedbackHandler).Methods("POST")
+			r.HandleFunc("/feedback", h.CORSHandler).Methods("OPTIONS")
+			r.HandleFunc("/health", h.HealthHandler).Methods("GET")
+			r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+			r.HandleFunc("/conversations", h.ConversationListHandler).Methods("GET")
+			r.HandleFunc("/conversations", h.CORSHandler).Methods("OPTIONS")
+			r.HandleFunc("/conversations/{id}", h.ConversationHandler).Methods("GET")
+			r.HandleFunc("/conversations/{id}", h.ConversationDeleteHandler).Methods("DELETE")
+			r.HandleFunc("/conversations/{id}", h.CORSHandler).Methods("OPTIONS")
+			r.HandleFunc("/quota", h.QuotaHandler).Methods("GET")
+			r.HandleFunc("/quota", h.CORSHandler).Methods("OPTIONS")
+			r.HandleFunc("/admin/usage", h.UsageHandler).Methods("GET")
+			r.HandleFunc("/admin/feedback", h.FeedbackListHandler).Methods("GET")
+			r.HandleFunc("/admin/feedback/export", h.FeedbackExportHandler).Methods("GET")
 			r.HandleFunc("/login", h.HandleLogin)
 			r.HandleFunc("/githubcallback", h.HandleGithubCallback)
 			r.HandleFunc("/apitoken", h.HandleApiToken)
@@ -141,14 +279,33 @@ func newStartServerCommand() *cobra.Command {
 			log.Infof("Default model provider: %s", h.DefaultProvider)
 			log.Infof("Default model: %s", h.DefaultModel)
 
+			// stop on SIGINT or SIGTERM so that the deferred cleanup runs
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			srv := &http.Server{Handler: r}
+			go func() {
+				<-ctx.Done()
+				log.Info("Shutting down server...")
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := srv.Shutdown(shutdownCtx); err != nil {
+					log.WithError(err).Error("error shutting down server")
+				}
+			}()
+
 			if config.ServerConfig.TLSCertFile != "" && config.ServerConfig.TLSKeyFile != "" {
 
 				log.Info("Server listening on TLS port 8443...")
-				err = http.ListenAndServeTLS(":8443", config.ServerConfig.TLSCertFile, config.ServerConfig.TLSKeyFile, r)
+				srv.Addr = ":8443"
+				err = srv.ListenAndServeTLS(config.ServerConfig.TLSCertFile, config.ServerConfig.TLSKeyFile)
 			} else {
 				log.Warn("No TLS configuration provided, traffic will not be encryption")
 				log.Info("Server listening on port 8080...")
-				err = http.ListenAndServe(":8080", r)
+				srv.Addr = ":8080"
+				err = srv.ListenAndServe()
+			}
+			if err == http.ErrServerClosed {
+				return nil
 			}
 			return err
 		},
@@ -189,7 +346,10 @@ func newInferCommand() *cobra.Command {
 				return fmt.Errorf("error loading configfile %s: %v", o.configFile, err)
 			}
 
-			models = initModels(config)
+			models, fallbacks, err = initModels(config)
+			if err != nil {
+				return err
+			}
 
 			if o.prompt == "" {
 				return fmt.Errorf("model prompt is required")
@@ -203,16 +363,18 @@ func newInferCommand() *cobra.Command {
 				o.modelId = config.DefaultModelId
 			}
 
-			m, err := getModel(o.provider, o.modelId)
-			if err != nil {
+			key := o.provider + "/" + o.modelId
+			if _, err := getModel(o.provider, o.modelId); err != nil {
 				return err
 			}
 
 			input := api.ModelInput{
-				Prompt: o.prompt,
+				Prompt:    o.prompt,
+				Template:  o.template,
+				Variables: o.variables,
 			}
 			log.Debugf("Using provider/model %s/%s for prompt:\n%s\n", o.provider, o.modelId, o.prompt)
-			response, err := model.InvokeModel(input, m)
+			response, err := model.InvokeModelChain(context.Background(), input, models, append([]string{key}, fallbacks[key]...), nil, nil)
 			if err != nil {
 				if response.Error != "" {
 					log.Debugf("Response(Error):\n%s", response.Error)
@@ -220,6 +382,9 @@ func newInferCommand() *cobra.Command {
 				return fmt.Errorf("error invoking the LLM: %v", err)
 			}
 
+			if len(response.FailedModels) > 0 {
+				log.Infof("Response produced by fallback model %s", response.Model)
+			}
 			fmt.Printf("Response:\n%s\n", response.Output)
 
 			return nil
@@ -229,6 +394,8 @@ func newInferCommand() *cobra.Command {
 	flags := cmd.Flags()
 	flags.StringVarP(&o.configFile, "config", "c", "", "Config file to use")
 	flags.StringVarP(&o.prompt, "inference", "i", "", "Model prompt to be inferred")
+	flags.StringVarP(&o.template, "template", "t", "", "Prompt template to render the prompt with.")
+	flags.StringToStringVar(&o.variables, "var", nil, "Prompt template variables, e.g. --var language=yaml")
 	flags.StringVarP(&o.modelId, "model", "m", "", "Which LLM model to use from the provider.")
 	flags.StringVarP(&o.provider, "provider", "p", "", "Which backend LLM provider to use.")
 	flags.StringVarP(&o.verbosity, "verbosity", "v", "info", "Log verbosity level (trace,debug,info,warn,error) (default info)")
@@ -237,23 +404,210 @@ func newInferCommand() *cobra.Command {
 
 }
 
-func initModels(config api.Config) map[string]api.Model {
+func newUsageCommand() *cobra.Command {
+	o := usageOptions{}
+
+	var cmd = &cobra.Command{
+		Use:   "usage",
+		Short: "Report usage by user and model from the usage ledger",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			level, err := log.ParseLevel(o.verbosity)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot parse log-level")
+			}
+			log.SetLevel(level)
+
+			if o.configFile == "" {
+				return fmt.Errorf("config file is required")
+			}
+			config, err := loadConfig(o.configFile)
+			if err != nil {
+				return fmt.Errorf("error loading configfile %s: %v", o.configFile, err)
+			}
+			if config.ServerConfig.UsageLedgerFile == "" {
+				return fmt.Errorf("no usageLedgerFile is configured")
+			}
+
+			filter, err := usage.ParseDateRange(o.from, o.to)
+			if err != nil {
+				return err
+			}
+			filter.User = o.user
+			filter.Model = o.model
+
+			ledger, err := usage.NewLedger(config.ServerConfig.UsageLedgerFile, nil)
+			if err != nil {
+				return err
+			}
+			defer ledger.Close()
+			report, err := ledger.Report(filter)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "USER\tMODEL\tREQUESTS\tFAILED\tPROMPT TOKENS\tCOMPLETION TOKENS\tTOTAL TOKENS\tCOST")
+			for _, s := range report {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%.4f\n", s.User, s.Model, s.Requests, s.FailedRequests, s.PromptTokens, s.CompletionTokens, s.TotalTokens, s.Cost)
+			}
+			return w.Flush()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.configFile, "config", "c", "", "Config file to use")
+	flags.StringVar(&o.from, "from", "", "First day to report, YYYY-MM-DD (default the start of the month)")
+	flags.StringVar(&o.to, "to", "", "Last day to report, YYYY-MM-DD (default today)")
+	flags.StringVarP(&o.user, "user", "u", "", "Only report usage by this user")
+	flags.StringVarP(&o.model, "model", "m", "", "Only report usage of this provider/modelId")
+	flags.StringVarP(&o.verbosity, "verbosity", "v", "info", "Log verbosity level (trace,debug,info,warn,error) (default info)")
+
+	return cmd
+}
+
+func newIndexCommand() *cobra.Command {
+	o := indexOptions{}
+
+	var cmd = &cobra.Command{
+		Use:   "index",
+		Short: "Build the retrieval index of a documentation directory",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			level, err := log.ParseLevel(o.verbosity)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot parse log-level")
+			}
+			log.SetLevel(level)
+
+			if o.dir == "" {
+				return fmt.Errorf("documentation directory is required")
+			}
+			// default to writing the index the config file reads
+			if o.output == "" && o.configFile != "" {
+				config, err := loadConfig(o.configFile)
+				if err != nil {
+					return fmt.Errorf("error loading configfile %s: %v", o.configFile, err)
+				}
+				o.output = config.Retrieval.Index
+			}
+			if o.output == "" {
+				return fmt.Errorf("output file is required")
+			}
+
+			index, err := rag.BuildIndex(o.dir)
+			if err != nil {
+				return err
+			}
+			if err := index.Save(o.output); err != nil {
+				return fmt.Errorf("error writing index %s: %v", o.output, err)
+			}
+			log.Infof("Indexed %d passages from %s to %s", index.Len(), o.dir, o.output)
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&o.configFile, "config", "c", "", "Config file naming the index to write")
+	flags.StringVarP(&o.dir, "dir", "d", "", "Directory of Markdown, AsciiDoc and YAML documentation to index")
+	flags.StringVarP(&o.output, "output", "o", "", "Index file to write (default the config file's retrieval index)")
+	flags.StringVarP(&o.verbosity, "verbosity", "v", "info", "Log verbosity level (trace,debug,info,warn,error) (default info)")
+
+	return cmd
+}
+
+// initTracing installs the configured span exporter, returning a function which flushes
+// and stops it.
+func initTracing(config api.TracingConfig) (func(context.Context) error, error) {
+	if config.ServiceName == "" {
+		config.ServiceName = "wisdom"
+	}
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		if config.Endpoint == "" {
+			config.Endpoint = "http://localhost:4318"
+		}
+		u, parseErr := url.Parse(config.Endpoint)
+		if parseErr != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid tracing endpoint %q", config.Endpoint)
+		}
+		options := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(u.Host),
+			otlptracehttp.WithHeaders(config.Headers),
+		}
+		if u.Scheme == "http" {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if path := strings.TrimSuffix(u.Path, "/"); path != "" {
+			options = append(options, otlptracehttp.WithURLPath(path))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %q, must be \"otlp\" or \"stdout\"", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s tracing exporter: %v", config.Exporter, err)
+	}
+	log.Infof("Exporting traces with the %s exporter", config.Exporter)
+	return tracing.Setup(exporter, config.ServiceName), nil
+}
+
+func initModels(config api.Config) (map[string]api.Model, map[string][]string, error) {
+	library, err := templates.NewLibrary(config.Templates)
+	if err != nil {
+		return nil, nil, err
+	}
+	var retriever *rag.Retriever
+	if config.Retrieval.Index != "" {
+		index, err := rag.LoadIndex(config.Retrieval.Index)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading retrieval index: %v", err)
+		}
+		log.Infof("Augmenting prompts from the %d passages of %s", index.Len(), config.Retrieval.Index)
+		retriever = rag.NewRetriever(index, config.Retrieval)
+	}
 	models := make(map[string]api.Model)
+	fallbacks := make(map[string][]string)
 	for _, m := range config.Models {
 		log.Debugf("Initializing model: %v", m)
-		switch m.Provider {
-		case "ibm":
-			models[m.Provider+"/"+m.ModelId] = ibm.NewIBMModel(m.ModelId, m.URL, m.UserId, m.APIKey)
-		case "openai":
-			models[m.Provider+"/"+m.ModelId] = openai.NewOpenAIModel(m.ModelId, m.URL, m.APIKey)
-		case "huggingface":
-			models[m.Provider+"/"+m.ModelId] = hf.NewHFModel(m.ModelId, m.URL, m.APIKey)
-
-		default:
-			log.Errorf("unknown provider: %s", m.Provider)
+		key := m.Provider + "/" + m.ModelId
+		if _, found := models[key]; found {
+			return nil, nil, fmt.Errorf("model %s is configured more than once", key)
+		}
+		renderer, err := templates.NewRenderer(library, m.DefaultTemplate)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid configuration for model %s: %v", key, err)
+		}
+		// passages are retrieved for what the user asked, but added once the template has
+		// wrapped it so that the template's instructions apply to the user's prompt
+		inputFilters := []api.InputFilter{renderer.RenderPrompt}
+		if retriever != nil {
+			inputFilters = []api.InputFilter{retriever.Retrieve, renderer.RenderPrompt, retriever.Augment}
+		}
+		instance, err := model.NewModel(m, inputFilters...)
+		if err != nil {
+			return nil, nil, err
+		}
+		models[key] = instance
+		if len(m.Fallbacks) > 0 {
+			fallbacks[key] = m.Fallbacks
+		}
+	}
+	for key, chain := range fallbacks {
+		for _, fallback := range chain {
+			if fallback == key {
+				return nil, nil, fmt.Errorf("model %s lists itself as a fallback", key)
+			}
+			if _, found := models[fallback]; !found {
+				return nil, nil, fmt.Errorf("fallback model %s for model %s is not configured", fallback, key)
+			}
 		}
 	}
-	return models
+	return models, fallbacks, nil
 }
 
 func getModel(provider, modelId string) (api.Model, error) {
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// ErrorCode is a machine readable identifier for the cause of a failed request.
+type ErrorCode string
+
+const (
+	ErrorCodeAuthFailed          ErrorCode = "auth_failed"
+	ErrorCodeForbidden           ErrorCode = "forbidden"
+	ErrorCodeNotFound            ErrorCode = "not_found"
+	ErrorCodeRateLimited         ErrorCode = "rate_limited"
+	ErrorCodeQuotaExceeded       ErrorCode = "quota_exceeded"
+	ErrorCodeTimeout             ErrorCode = "timeout"
+	ErrorCodeBadRequest          ErrorCode = "bad_request"
+	ErrorCodeUpstreamUnavailable ErrorCode = "upstream_unavailable"
+	ErrorCodeFilterRejected      ErrorCode = "filter_rejected"
+	ErrorCodeModelUnavailable    ErrorCode = "model_unavailable"
+	ErrorCodeInternal            ErrorCode = "internal_error"
+)
+
+// Error is an error with a machine readable code identifying its cause.  Errors match
+// any other Error with the same code, so errors.Is(err, ErrTimeout) is true for every
+// timeout regardless of its message.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	// Filter is the name of the filter which rejected the input or response, if any.
+	Filter string
+	Err    error
+}
+
+var (
+	ErrAuthFailed          = &Error{Code: ErrorCodeAuthFailed, Message: "authentication failed"}
+	ErrRateLimited         = &Error{Code: ErrorCodeRateLimited, Message: "rate limited"}
+	ErrQuotaExceeded       = &Error{Code: ErrorCodeQuotaExceeded, Message: "token quota exceeded"}
+	ErrTimeout             = &Error{Code: ErrorCodeTimeout, Message: "model invocation timed out"}
+	ErrBadRequest          = &Error{Code: ErrorCodeBadRequest, Message: "bad request"}
+	ErrUpstreamUnavailable = &Error{Code: ErrorCodeUpstreamUnavailable, Message: "model provider unavailable"}
+	ErrFilterRejected      = &Error{Code: ErrorCodeFilterRejected, Message: "rejected by filter"}
+	// ErrModelUnavailable is returned without invoking a model whose circuit breaker is open.
+	ErrModelUnavailable = &Error{Code: ErrorCodeModelUnavailable, Message: "model unavailable"}
+	// ErrForbidden is returned to authenticated users who may not access the resource.
+	ErrForbidden = &Error{Code: ErrorCodeForbidden, Message: "forbidden"}
+	// ErrNotFound is returned for requests naming a resource, such as a conversation, which
+	// does not exist.
+	ErrNotFound = &Error{Code: ErrorCodeNotFound, Message: "not found"}
+)
+
+func NewError(code ErrorCode, err error, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Err:     err,
+	}
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Filter != "" {
+		msg = fmt.Sprintf("%s %s", e.Filter, msg)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// ErrorFromStatus classifies a failed model provider api response by its status code.
+func ErrorFromStatus(statusCode int, format string, args ...interface{}) *Error {
+	code := ErrorCodeUpstreamUnavailable
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		code = ErrorCodeAuthFailed
+	case http.StatusTooManyRequests:
+		code = ErrorCodeRateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		code = ErrorCodeTimeout
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		code = ErrorCodeBadRequest
+	}
+	return NewError(code, nil, format, args...)
+}
+
+// ErrorResponse is the body returned by the server for a failed request.
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"requestId"`
+	Model     string    `json:"model,omitempty"`
+	Filter    string    `json:"filter,omitempty"`
+}
+
+// ErrorCodeOf returns the code of err, or ErrorCodeInternal if it is not an Error.
+func ErrorCodeOf(err error) ErrorCode {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	return ErrorCodeInternal
+}
+
+// FilterName returns the package qualified name of a filter function, e.g. yaml.YamlLinter.
+func FilterName(filter interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(filter).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
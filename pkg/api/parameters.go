@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// GenerationParameters control how a model generates its output.  Unset fields use the
+// provider's defaults.
+type GenerationParameters struct {
+	MaxTokens   *int     `yaml:"maxTokens" json:"maxTokens"`
+	Temperature *float64 `yaml:"temperature" json:"temperature"`
+	TopP        *float64 `yaml:"topP" json:"topP"`
+	Stop        []string `yaml:"stop" json:"stop"`
+	// TimeLimit is the maximum time in seconds the model should spend generating output.
+	TimeLimit *float64 `yaml:"timeLimit" json:"timeLimit"`
+
+	// Extra holds provider specific parameters, such as the IBM task id.  Extra parameters
+	// can only be set in the config file, not overridden per request.
+	Extra map[string]interface{} `yaml:"extra" json:"-"`
+}
+
+// GenerationLimits are the admin configured maximums for the generation parameters of a
+// model.  Zero values are unbounded.
+type GenerationLimits struct {
+	MaxTokens        int     `yaml:"maxTokens"`
+	Temperature      float64 `yaml:"temperature"`
+	TopP             float64 `yaml:"topP"`
+	StopSequences    int     `yaml:"stopSequences"`
+	TimeLimitSeconds float64 `yaml:"timeLimit"`
+}
+
+// Validate returns a bad request error if any of the parameters is outside its valid range.
+func (p GenerationParameters) Validate() error {
+	if p.MaxTokens != nil && *p.MaxTokens <= 0 {
+		return NewError(ErrorCodeBadRequest, nil, "maxTokens must be greater than 0, got %d", *p.MaxTokens)
+	}
+	if p.Temperature != nil && *p.Temperature < 0 {
+		return NewError(ErrorCodeBadRequest, nil, "temperature must not be negative, got %g", *p.Temperature)
+	}
+	if p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1) {
+		return NewError(ErrorCodeBadRequest, nil, "topP must be between 0 and 1, got %g", *p.TopP)
+	}
+	if p.TimeLimit != nil && *p.TimeLimit <= 0 {
+		return NewError(ErrorCodeBadRequest, nil, "timeLimit must be greater than 0, got %g", *p.TimeLimit)
+	}
+	return nil
+}
+
+// ResolveParameters applies the per request overrides to the configured defaults and
+// clamps the result to the configured limits.
+func ResolveParameters(defaults GenerationParameters, limits GenerationLimits, overrides *GenerationParameters) GenerationParameters {
+	p := defaults
+	if overrides != nil {
+		if overrides.MaxTokens != nil {
+			p.MaxTokens = overrides.MaxTokens
+		}
+		if overrides.Temperature != nil {
+			p.Temperature = overrides.Temperature
+		}
+		if overrides.TopP != nil {
+			p.TopP = overrides.TopP
+		}
+		if overrides.Stop != nil {
+			p.Stop = overrides.Stop
+		}
+		if overrides.TimeLimit != nil {
+			p.TimeLimit = overrides.TimeLimit
+		}
+	}
+
+	if limits.MaxTokens > 0 && p.MaxTokens != nil && *p.MaxTokens > limits.MaxTokens {
+		v := limits.MaxTokens
+		p.MaxTokens = &v
+	}
+	if limits.Temperature > 0 && p.Temperature != nil && *p.Temperature > limits.Temperature {
+		v := limits.Temperature
+		p.Temperature = &v
+	}
+	if limits.TopP > 0 && p.TopP != nil && *p.TopP > limits.TopP {
+		v := limits.TopP
+		p.TopP = &v
+	}
+	if limits.StopSequences > 0 && len(p.Stop) > limits.StopSequences {
+		p.Stop = p.Stop[:limits.StopSequences]
+	}
+	if limits.TimeLimitSeconds > 0 && (p.TimeLimit == nil || *p.TimeLimit > limits.TimeLimitSeconds) {
+		v := limits.TimeLimitSeconds
+		p.TimeLimit = &v
+	}
+	return p
+}
+
+// ExtraString returns the named provider specific parameter, or def if it is not set.
+func (p GenerationParameters) ExtraString(name, def string) string {
+	v, found := p.Extra[name]
+	if !found {
+		return def
+	}
+	return fmt.Sprint(v)
+}
+
+// ExtraInt returns the named provider specific parameter as an integer, or def if it is not set.
+func (p GenerationParameters) ExtraInt(name string, def int) (int, error) {
+	v, found := p.Extra[name]
+	if !found {
+		return def, nil
+	}
+	switch i := v.(type) {
+	case int:
+		return i, nil
+	case string:
+		return strconv.Atoi(i)
+	default:
+		return def, fmt.Errorf("parameter %s must be an integer, got %v", name, v)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"testing"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestValidateParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  GenerationParameters
+		wantErr bool
+	}{
+		{name: "unset", params: GenerationParameters{}},
+		{name: "valid", params: GenerationParameters{MaxTokens: intPtr(100), Temperature: floatPtr(0.7), TopP: floatPtr(0.9), TimeLimit: floatPtr(30)}},
+		{name: "maxTokens of 1", params: GenerationParameters{MaxTokens: intPtr(1)}},
+		{name: "zero maxTokens", params: GenerationParameters{MaxTokens: intPtr(0)}, wantErr: true},
+		{name: "negative maxTokens", params: GenerationParameters{MaxTokens: intPtr(-5)}, wantErr: true},
+		{name: "zero temperature", params: GenerationParameters{Temperature: floatPtr(0)}},
+		{name: "negative temperature", params: GenerationParameters{Temperature: floatPtr(-0.1)}, wantErr: true},
+		{name: "topP of 0", params: GenerationParameters{TopP: floatPtr(0)}},
+		{name: "topP of 1", params: GenerationParameters{TopP: floatPtr(1)}},
+		{name: "negative topP", params: GenerationParameters{TopP: floatPtr(-0.5)}, wantErr: true},
+		{name: "topP above 1", params: GenerationParameters{TopP: floatPtr(1.5)}, wantErr: true},
+		{name: "zero timeLimit", params: GenerationParameters{TimeLimit: floatPtr(0)}, wantErr: true},
+		{name: "negative timeLimit", params: GenerationParameters{TimeLimit: floatPtr(-1)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.params.Validate()
+			if tt.wantErr {
+				if !errors.Is(err, ErrBadRequest) {
+					t.Errorf("expected a bad request error, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestResolveParameters(t *testing.T) {
+	limits := GenerationLimits{MaxTokens: 200, Temperature: 1, TopP: 0.95, StopSequences: 1, TimeLimitSeconds: 60}
+	defaults := GenerationParameters{MaxTokens: intPtr(100), Temperature: floatPtr(0.5)}
+
+	p := ResolveParameters(defaults, limits, nil)
+	if *p.MaxTokens != 100 || *p.Temperature != 0.5 || *p.TimeLimit != 60 {
+		t.Errorf("expected the defaults with the time limit, got %+v", p)
+	}
+
+	p = ResolveParameters(defaults, limits, &GenerationParameters{MaxTokens: intPtr(500), Temperature: floatPtr(2), TopP: floatPtr(1), Stop: []string{"a", "b"}, TimeLimit: floatPtr(120)})
+	if *p.MaxTokens != 200 || *p.Temperature != 1 || *p.TopP != 0.95 || len(p.Stop) != 1 || *p.TimeLimit != 60 {
+		t.Errorf("expected overrides clamped to the limits, got %+v", p)
+	}
+}
@@ -1,7 +1,16 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/golang-jwt/jwt/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v2"
+
+	"github.com/openshift/wisdom/pkg/tracing"
 )
 
 type Filter struct {
@@ -9,8 +18,8 @@ type Filter struct {
 	ResponseFilterChain []ResponseFilter
 }
 
-type InputFilter func(input ModelInput) (ModelInput, error)
-type ResponseFilter func(response ModelResponse) (ModelResponse, error)
+type InputFilter func(ctx context.Context, input ModelInput) (ModelInput, error)
+type ResponseFilter func(ctx context.Context, response ModelResponse) (ModelResponse, error)
 
 func NewFilter(inputFilters []InputFilter, responseFilters []ResponseFilter) Filter {
 	filter := Filter{
@@ -20,35 +29,60 @@ func NewFilter(inputFilters []InputFilter, responseFilters []ResponseFilter) Fil
 	return filter
 }
 
-func (f Filter) FilterInput(input ModelInput) (ModelInput, error) {
+func (f Filter) FilterInput(ctx context.Context, input ModelInput) (ModelInput, error) {
 	output := input
 	var err error
 	for _, filter := range f.InputFilterChain {
-		output, err = filter(output)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return output, err
 		}
+		filterCtx, span := tracing.Start(ctx, FilterName(filter), trace.SpanKindInternal)
+		output, err = filter(filterCtx, output)
+		tracing.SetError(span, err)
+		span.End()
+		if err != nil {
+			// filters which identify the cause, such as a bad request, keep their code
+			var apiErr *Error
+			if errors.As(err, &apiErr) {
+				return output, err
+			}
+			return output, &Error{Code: ErrorCodeFilterRejected, Message: "rejected the input", Filter: FilterName(filter), Err: err}
+		}
 	}
 	return output, err
 }
 
-func (f Filter) FilterResponse(response ModelResponse) (ModelResponse, error) {
+func (f Filter) FilterResponse(ctx context.Context, response ModelResponse) (ModelResponse, error) {
 	output := response
 	var err error
 	for _, filter := range f.ResponseFilterChain {
-		output, err = filter(output)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return output, err
 		}
+		filterCtx, span := tracing.Start(ctx, FilterName(filter), trace.SpanKindInternal)
+		output, err = filter(filterCtx, output)
+		tracing.SetError(span, err)
+		span.End()
+		if err != nil {
+			return output, &Error{Code: ErrorCodeFilterRejected, Message: "rejected the response", Filter: FilterName(filter), Err: err}
+		}
 	}
 	return output, err
 }
 
 type Model interface {
-	Invoke(ModelInput) (ModelResponse, error)
+	Invoke(context.Context, ModelInput) (ModelResponse, error)
 	GetFilter() Filter
 }
 
+// StreamingModel is implemented by models which can return their output incrementally.
+type StreamingModel interface {
+	Model
+	// InvokeStream calls onToken with each chunk of output as it arrives and returns the
+	// assembled, unfiltered response once the model has finished generating.
+	InvokeStream(ctx context.Context, input ModelInput, onToken func(token string) error) (ModelResponse, error)
+}
+
 // ModelInput represents the payload for the prompt_request endpoint.
 type ModelInput struct {
 	UserId         string `json:"userid"`
@@ -58,16 +92,86 @@ type ModelInput struct {
 	Prompt         string `json:"prompt"`
 	Context        string `json:"context"`
 	ConversationID string `json:"conversationId"`
+
+	// Parameters optionally override the model's configured generation parameters,
+	// within the limits configured for the model.
+	Parameters *GenerationParameters `json:"parameters"`
+
+	// Template names the prompt template rendering the prompt, the model's default template
+	// is used when it is not set.  Variables are the values of the template's variables.
+	Template  string            `json:"template"`
+	Variables map[string]string `json:"variables"`
+
+	// NoCache skips the response cache, always invoking the model.
+	NoCache bool `json:"noCache"`
+
+	// History holds the earlier turns of the conversation, oldest first.  It is loaded by
+	// the server from the conversation store rather than sent by clients.
+	History []Message `json:"-"`
+
+	// Sources are set by input filters which add documentation passages to the prompt.
+	Sources []Source `json:"-"`
+	// Passages is the documentation retrieved for the prompt, held until it is added to the
+	// prompt once any prompt template has been rendered.
+	Passages string `json:"-"`
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a turn of a conversation.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
 }
 
 type ModelResponse struct {
-	Input          string `json:"input_tokens"`
+	Input          string `json:"input"`
 	Status         string `json:"status"`
 	RequestID      string `json:"requestId"`
 	ConversationID string `json:"conversationId"`
 	Output         string `json:"output"`
 	RawOutput      string `json:"raw_output"`
 	Error          string `json:"error"`
+
+	// Candidates holds every sequence generated by models which return more than one,
+	// the first of which is used as the output.
+	Candidates []string `json:"candidates,omitempty"`
+
+	Usage Usage `json:"usage"`
+
+	// Model is the provider/modelId which produced the response.
+	Model string `json:"model"`
+	// FailedModels lists the models which were tried, and failed, before Model.
+	FailedModels []ModelFailure `json:"failedModels,omitempty"`
+
+	// Cached is set when the response was reused from the response cache.
+	Cached bool `json:"cached,omitempty"`
+	// Shared is set when the response was produced for a concurrent identical request.
+	Shared bool `json:"shared,omitempty"`
+
+	// Sources are the documentation passages added to the prompt.
+	Sources []Source `json:"sources,omitempty"`
+}
+
+// Source is a documentation passage used to augment a prompt.
+type Source struct {
+	Path  string  `json:"path"`
+	Title string  `json:"title"`
+	Score float64 `json:"score"`
+}
+
+// ModelFailure records why a model in a fallback chain did not produce the response.
+type ModelFailure struct {
+	Model string    `json:"model"`
+	Code  ErrorCode `json:"code"`
+	Error string    `json:"error"`
+	// Usage is the model's usage by the failed attempt, such as a response rejected by
+	// its response filters.
+	Usage Usage `json:"usage"`
 }
 
 type Claims struct {
@@ -96,6 +200,101 @@ type ModelConfig struct {
 	Provider string `yaml:"provider"`
 	ModelId  string `yaml:"modelId"`
 	URL      string `yaml:"url"`
+
+	// Timeout bounds a single invocation of the model, e.g. "30s".  Zero means no timeout.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Parameters are the default generation parameters for the model.
+	Parameters GenerationParameters `yaml:"parameters"`
+	// ParameterLimits bound the generation parameters requests may ask for.
+	ParameterLimits GenerationLimits `yaml:"parameterLimits"`
+
+	// Context controls how the context sent with requests is passed to the model.
+	Context ContextConfig `yaml:"context"`
+
+	// DefaultTemplate names the prompt template used for requests which do not name one.
+	DefaultTemplate string `yaml:"defaultTemplate"`
+
+	// Retry controls retrying of failed requests to the provider.
+	Retry RetryConfig `yaml:"retry"`
+
+	// CircuitBreaker controls failing fast when the model is unhealthy.
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuitBreaker"`
+
+	// Pricing is used to estimate the cost of the model's usage.
+	Pricing PricingConfig `yaml:"pricing"`
+
+	// Fallbacks are the provider/modelId of models to try, in order, when this model fails
+	// to produce a response which passes its filters.
+	Fallbacks []string `yaml:"fallbacks"`
+
+	// Options holds provider specific settings, see DecodeOptions.
+	Options map[string]interface{} `yaml:"options"`
+}
+
+// ContextConfig controls how the context sent with requests is passed to the model.  Chat
+// models receive the context as a system message, other models as part of the prompt.
+type ContextConfig struct {
+	// MaxLength is the number of bytes of context passed to the model, defaults to 8000.
+	// Longer contexts are truncated from the start, keeping the most recent context.
+	MaxLength int `yaml:"maxLength"`
+	// Template renders the prompt for models which are not chat models, with the
+	// .Prompt and the truncated .Context.  The default template puts the context, if
+	// any, ahead of the prompt.
+	Template string `yaml:"template"`
+}
+
+// RetryConfig controls retrying of requests which fail to connect or are rejected with a
+// 429, 502, 503 or 504 status.  By default requests are not retried.
+type RetryConfig struct {
+	MaxRetries int `yaml:"maxRetries"`
+	// InitialBackoff is the delay before the first retry, doubling for each subsequent
+	// retry up to MaxBackoff.  A Retry-After header sent by the provider takes precedence,
+	// but requests asked to wait longer than MaxBackoff fail without being retried.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
+// CircuitBreakerConfig controls the circuit breaker around a model.  The breaker is
+// disabled unless FailureRate is set.
+type CircuitBreakerConfig struct {
+	// FailureRate is the fraction of invocations, between 0 and 1, which must fail within
+	// Window, after at least MinRequests invocations, to open the breaker.
+	FailureRate float64       `yaml:"failureRate"`
+	MinRequests int           `yaml:"minRequests"`
+	Window      time.Duration `yaml:"window"`
+	// OpenDuration is how long the breaker stays open before allowing a probe invocation.
+	OpenDuration time.Duration `yaml:"openDuration"`
+}
+
+// PricingConfig is the price of a model's tokens, in any currency, per thousand tokens.
+type PricingConfig struct {
+	PromptTokens     float64 `yaml:"promptTokens"`
+	CompletionTokens float64 `yaml:"completionTokens"`
+}
+
+// Cost returns the estimated cost of usage.
+func (p PricingConfig) Cost(usage Usage) float64 {
+	return (float64(usage.PromptTokens)*p.PromptTokens + float64(usage.CompletionTokens)*p.CompletionTokens) / 1000
+}
+
+// DecodeOptions decodes the provider specific options into out, failing on any option
+// out does not define.  A nil out indicates the provider accepts no options.
+func (c ModelConfig) DecodeOptions(out interface{}) error {
+	if out == nil {
+		if len(c.Options) > 0 {
+			return fmt.Errorf("provider %s does not accept options", c.Provider)
+		}
+		return nil
+	}
+	buf, err := yaml.Marshal(c.Options)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(buf, out); err != nil {
+		return fmt.Errorf("invalid options for provider %s: %v", c.Provider, err)
+	}
+	return nil
 }
 
 type ServerConfig struct {
@@ -108,11 +307,163 @@ type ServerConfig struct {
 	SessionEncryptionKey string          `yaml:"sessionEncryptionKey"`
 	TokenEncryptionKey   string          `yaml:"tokenEncryptionKey"`
 	AllowedUsers         map[string]bool `yaml:"allowedUsers"`
+	// AdminUsers may access the /admin endpoints.
+	AdminUsers map[string]bool `yaml:"adminUsers"`
+	// UsageLedgerFile is the file usage is recorded to, usage is not recorded when unset.
+	UsageLedgerFile string `yaml:"usageLedgerFile"`
+	// RateLimit limits the rate of inference requests.
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+	// Quota limits the tokens each user may use.
+	Quota QuotaConfig `yaml:"quota"`
+	// Cache configures the response cache.
+	Cache CacheConfig `yaml:"cache"`
+	// Tracing configures exporting of request traces.
+	Tracing TracingConfig `yaml:"tracing"`
+	// AuditLog configures logging of inference exchanges.
+	AuditLog AuditLogConfig `yaml:"auditLog"`
+	// Feedback configures storage of feedback on responses.
+	Feedback FeedbackConfig `yaml:"feedback"`
+	// Conversations configures server side conversation history.
+	Conversations ConversationConfig `yaml:"conversations"`
+}
+
+// ConversationConfig configures server side conversation history, which is disabled unless
+// Enabled is set.
+type ConversationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxTurns is the number of prompts and responses kept, and replayed to the model, for
+	// each conversation, defaults to 10.
+	MaxTurns int `yaml:"maxTurns"`
+	// TTL is how long a conversation is kept after its last change, defaults to 720h.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxConversations is the number of conversations kept for each user, the least recently
+	// changed being removed first, defaults to 100.
+	MaxConversations int `yaml:"maxConversations"`
+	// File persists conversations across restarts when set.
+	File string `yaml:"file"`
+}
+
+// FeedbackConfig configures storage of feedback on responses.
+type FeedbackConfig struct {
+	// File stores feedback as JSON lines, feedback is held in memory when unset.
+	File string `yaml:"file"`
+	// MaxResponses is the number of recent responses feedback is accepted for, defaults to
+	// 10000.
+	MaxResponses int `yaml:"maxResponses"`
+}
+
+// AuditLogConfig configures the audit log of inference exchanges, which is disabled unless
+// File is set.
+type AuditLogConfig struct {
+	File string `yaml:"file"`
+	// MaxSizeMB is the size at which the file is rotated, defaults to 100.
+	MaxSizeMB int `yaml:"maxSizeMB"`
+	// MaxFiles is the number of rotated files kept, defaults to 10.
+	MaxFiles int `yaml:"maxFiles"`
+	// ExcludeAPIKeys omits the api keys sent with requests, otherwise they are masked.
+	ExcludeAPIKeys bool `yaml:"excludeAPIKeys"`
+	// RedactPrompts replaces the prompt and context of users not in ConsentedUsers.
+	RedactPrompts  bool            `yaml:"redactPrompts"`
+	ConsentedUsers map[string]bool `yaml:"consentedUsers"`
+}
+
+// TracingConfig configures the exporter traces are sent to.  Tracing is disabled unless an
+// exporter is set.
+type TracingConfig struct {
+	// Exporter is "otlp", to send traces to an OpenTelemetry collector, or "stdout".
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP endpoint of the collector, defaults to http://localhost:4318.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every export request, e.g. for authentication.
+	Headers map[string]string `yaml:"headers"`
+	// ServiceName identifies the server in traces, defaults to wisdom.
+	ServiceName string `yaml:"serviceName"`
+}
+
+// CacheConfig configures the response cache, which is disabled unless MaxEntries is set.
+type CacheConfig struct {
+	// MaxEntries is the number of responses cached, the least recently used are evicted first.
+	MaxEntries int `yaml:"maxEntries"`
+	// TTL is how long responses are cached for, defaults to 1h.
+	TTL time.Duration `yaml:"ttl"`
+	// File persists the cache across restarts when set.
+	File string `yaml:"file"`
+}
+
+// QuotaConfig configures token quotas for each user.
+type QuotaConfig struct {
+	// Default is the quota of each user without an entry in Users.
+	Default UserQuota `yaml:"default"`
+	// Users overrides the default quota for individual users.
+	Users map[string]UserQuota `yaml:"users"`
+}
+
+// UserQuota limits a user's tokens across all models and, in Models, of individual
+// provider/modelIds.
+type UserQuota struct {
+	TokenQuota `yaml:",inline"`
+	Models     map[string]TokenQuota `yaml:"models"`
+}
+
+// TokenQuota is the number of tokens which may be used in each UTC day and month, zero is
+// unlimited.
+type TokenQuota struct {
+	Daily   int `yaml:"daily"`
+	Monthly int `yaml:"monthly"`
+}
+
+// RateLimitConfig configures token bucket rate limits for inference requests.  A request
+// must be allowed by both the user's limit and the global limit.
+type RateLimitConfig struct {
+	// Default is the limit applied to each user without an entry in Users.
+	Default RateLimit `yaml:"default"`
+	// Users overrides the default limit for individual users.
+	Users map[string]RateLimit `yaml:"users"`
+	// Global is shared by all users.
+	Global RateLimit `yaml:"global"`
+}
+
+// RateLimit is a token bucket refilled at RequestsPerMinute holding up to Burst requests.
+// A zero RequestsPerMinute is unlimited, a zero Burst defaults to RequestsPerMinute.
+type RateLimit struct {
+	RequestsPerMinute float64 `yaml:"requestsPerMinute"`
+	Burst             int     `yaml:"burst"`
 }
 
 type Config struct {
-	Models          []ModelConfig `yaml:"models"`
-	ServerConfig    ServerConfig  `yaml:"serverConfig"`
-	DefaultProvider string        `yaml:"defaultProvider"`
-	DefaultModelId  string        `yaml:"defaultModelId"`
+	Models          []ModelConfig          `yaml:"models"`
+	Templates       []PromptTemplateConfig `yaml:"templates"`
+	Retrieval       RetrievalConfig        `yaml:"retrieval"`
+	ServerConfig    ServerConfig           `yaml:"serverConfig"`
+	DefaultProvider string                 `yaml:"defaultProvider"`
+	DefaultModelId  string                 `yaml:"defaultModelId"`
+}
+
+// RetrievalConfig controls augmenting prompts with passages retrieved from a documentation
+// index built with the index command.  Retrieval is disabled unless Index is set.
+type RetrievalConfig struct {
+	Index string `yaml:"index"`
+	// TopK is the maximum number of passages added to each prompt, defaults to 3.
+	TopK int `yaml:"topK"`
+	// MaxTokens bounds the estimated tokens of the passages added to each prompt, defaults to 1000.
+	MaxTokens int `yaml:"maxTokens"`
+	// MinScore is the BM25 score below which passages are not used.
+	MinScore float64 `yaml:"minScore"`
+}
+
+// PromptTemplateConfig is a named prompt template which requests can select by name.
+type PromptTemplateConfig struct {
+	Name string `yaml:"name"`
+	// Template is a Go text/template rendering the prompt from the request's .Prompt and
+	// the template's variables, e.g. "Write an Ansible task in {{.language}} to {{.Prompt}}".
+	Template  string             `yaml:"template"`
+	Variables []TemplateVariable `yaml:"variables"`
+}
+
+// TemplateVariable is a variable of a prompt template.  Requests must supply a value for
+// required variables, other variables take their default when not supplied.
+type TemplateVariable struct {
+	Name     string `yaml:"name"`
+	Required bool   `yaml:"required"`
+	Default  string `yaml:"default"`
 }
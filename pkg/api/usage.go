@@ -0,0 +1,40 @@
+package api
+
+// Usage is the number of tokens consumed by a model invocation.
+type Usage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+	TotalTokens      int `json:"totalTokens"`
+	// Estimated is set when some of the counts were estimated from the text because the
+	// provider did not report them.
+	Estimated bool `json:"estimated"`
+}
+
+// charsPerToken is a rough average for English text and code across common tokenizers.
+const charsPerToken = 4
+
+// EstimateTokens estimates the number of tokens in text.
+func EstimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// EstimateInputTokens estimates the prompt tokens of input, counting its context and
+// conversation history as well as its prompt.
+func EstimateInputTokens(input ModelInput) int {
+	tokens := EstimateTokens(input.Prompt) + EstimateTokens(input.Context)
+	for _, message := range input.History {
+		tokens += EstimateTokens(message.Content)
+	}
+	return tokens
+}
+
+// EstimateUsage estimates the usage of an invocation from its prompt and completion.
+func EstimateUsage(prompt, completion string) Usage {
+	usage := Usage{
+		PromptTokens:     EstimateTokens(prompt),
+		CompletionTokens: EstimateTokens(completion),
+		Estimated:        true,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/jsonl"
+)
+
+const (
+	defaultMaxSizeMB = 100
+	defaultMaxFiles  = 10
+
+	redacted = "[redacted]"
+)
+
+// Record is a single inference exchange.
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	User      string    `json:"user"`
+	// APIKey is masked to its last 4 characters, or omitted if configured.
+	APIKey         string             `json:"apiKey,omitempty"`
+	Model          string             `json:"model"`
+	Prompt         string             `json:"prompt"`
+	Context        string             `json:"context,omitempty"`
+	FilteredPrompt string             `json:"filteredPrompt"`
+	RawOutput      string             `json:"rawOutput"`
+	Output         string             `json:"output"`
+	Error          string             `json:"error,omitempty"`
+	FailedModels   []api.ModelFailure `json:"failedModels,omitempty"`
+	LatencyMs      int64              `json:"latencyMs"`
+	Usage          api.Usage          `json:"usage"`
+	Cached         bool               `json:"cached,omitempty"`
+	Shared         bool               `json:"shared,omitempty"`
+	Streamed       bool               `json:"streamed,omitempty"`
+	// Redacted is set when the prompt and context were removed because the user has not
+	// consented to their content being logged.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// NewRecord builds the record of an exchange from the request input and the response.
+func NewRecord(requestID, user string, input api.ModelInput, response api.ModelResponse, latency time.Duration, err error) Record {
+	record := Record{
+		Time:           time.Now().UTC(),
+		RequestID:      requestID,
+		User:           user,
+		APIKey:         input.APIKey,
+		Model:          response.Model,
+		Prompt:         input.Prompt,
+		Context:        input.Context,
+		FilteredPrompt: response.Input,
+		RawOutput:      response.RawOutput,
+		Output:         response.Output,
+		Error:          response.Error,
+		FailedModels:   response.FailedModels,
+		LatencyMs:      latency.Milliseconds(),
+		Usage:          response.Usage,
+		Cached:         response.Cached,
+		Shared:         response.Shared,
+	}
+	if record.Model == "" {
+		record.Model = input.Provider + "/" + input.ModelId
+	}
+	if err != nil && record.Error == "" {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// Logger appends records to a JSON lines file, rotating it to numbered backups, e.g.
+// audit.jsonl.1, once it reaches its maximum size.
+type Logger struct {
+	config api.AuditLogConfig
+
+	lock sync.Mutex
+	file *jsonl.File
+}
+
+func NewLogger(config api.AuditLogConfig) (*Logger, error) {
+	if config.MaxSizeMB <= 0 {
+		config.MaxSizeMB = defaultMaxSizeMB
+	}
+	if config.MaxFiles <= 0 {
+		config.MaxFiles = defaultMaxFiles
+	}
+	l := &Logger{config: config}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	file, err := jsonl.Open(l.config.File)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %v", l.config.File, err)
+	}
+	l.file = file
+	return nil
+}
+
+// Log redacts and appends the record.
+func (l *Logger) Log(record Record) error {
+	if l.config.ExcludeAPIKeys {
+		record.APIKey = ""
+	} else {
+		record.APIKey = mask(record.APIKey)
+	}
+	if l.config.RedactPrompts && !l.config.ConsentedUsers[record.User] {
+		record.Prompt = redacted
+		record.FilteredPrompt = redacted
+		if record.Context != "" {
+			record.Context = redacted
+		}
+		record.Redacted = true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		// a previous rotation failed to reopen the log
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	if err := l.file.Append(record); err != nil {
+		return err
+	}
+	if l.file.Size() >= int64(l.config.MaxSizeMB)*1024*1024 {
+		return l.rotate()
+	}
+	return nil
+}
+
+// rotate shifts the numbered backups up by one, discarding the oldest, and starts a new file.
+func (l *Logger) rotate() error {
+	l.file.Close()
+	l.file = nil
+	path := l.config.File
+	os.Remove(fmt.Sprintf("%s.%d", path, l.config.MaxFiles))
+	for i := l.config.MaxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log %s: %v", path, err)
+	}
+	return l.open()
+}
+
+func (l *Logger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func mask(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/jsonl"
+)
+
+// readRecords returns the records in the log file at path.
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	records := []Record{}
+	err := jsonl.Read(path, func(line int, data []byte) error {
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestLogRedaction(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       api.AuditLogConfig
+		record       Record
+		wantAPIKey   string
+		wantPrompt   string
+		wantContext  string
+		wantRedacted bool
+	}{
+		{
+			name:        "api keys are masked",
+			record:      Record{User: "alice", APIKey: "sk-0123456789", Prompt: "install nginx", Context: "- hosts: all"},
+			wantAPIKey:  "****6789",
+			wantPrompt:  "install nginx",
+			wantContext: "- hosts: all",
+		},
+		{
+			name:       "short api keys are masked entirely",
+			record:     Record{User: "alice", APIKey: "short", Prompt: "install nginx"},
+			wantAPIKey: "****",
+			wantPrompt: "install nginx",
+		},
+		{
+			name:       "api keys are excluded",
+			config:     api.AuditLogConfig{ExcludeAPIKeys: true},
+			record:     Record{User: "alice", APIKey: "sk-0123456789", Prompt: "install nginx"},
+			wantPrompt: "install nginx",
+		},
+		{
+			name:         "prompts are redacted",
+			config:       api.AuditLogConfig{RedactPrompts: true},
+			record:       Record{User: "alice", Prompt: "install nginx", Context: "- hosts: all"},
+			wantPrompt:   redacted,
+			wantContext:  redacted,
+			wantRedacted: true,
+		},
+		{
+			name:         "empty context is not redacted",
+			config:       api.AuditLogConfig{RedactPrompts: true},
+			record:       Record{User: "alice", Prompt: "install nginx"},
+			wantPrompt:   redacted,
+			wantRedacted: true,
+		},
+		{
+			name:        "prompts of consented users are kept",
+			config:      api.AuditLogConfig{RedactPrompts: true, ConsentedUsers: map[string]bool{"alice": true}},
+			record:      Record{User: "alice", Prompt: "install nginx", Context: "- hosts: all"},
+			wantPrompt:  "install nginx",
+			wantContext: "- hosts: all",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.File = filepath.Join(t.TempDir(), "audit.jsonl")
+			l, err := NewLogger(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.record.FilteredPrompt = tt.record.Prompt
+			if err := l.Log(tt.record); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			records := readRecords(t, config.File)
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			got := records[0]
+			if got.APIKey != tt.wantAPIKey {
+				t.Errorf("got api key %q, want %q", got.APIKey, tt.wantAPIKey)
+			}
+			if got.Prompt != tt.wantPrompt || got.FilteredPrompt != tt.wantPrompt {
+				t.Errorf("got prompt %q and filtered prompt %q, want %q", got.Prompt, got.FilteredPrompt, tt.wantPrompt)
+			}
+			if got.Context != tt.wantContext {
+				t.Errorf("got context %q, want %q", got.Context, tt.wantContext)
+			}
+			if got.Redacted != tt.wantRedacted {
+				t.Errorf("got redacted %v, want %v", got.Redacted, tt.wantRedacted)
+			}
+		})
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	const (
+		maxFiles = 2
+		// records is the number of records written, of which recordsPerFile fill each file
+		records        = 25
+		recordsPerFile = 6
+	)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewLogger(api.AuditLogConfig{File: path, MaxSizeMB: 1, MaxFiles: maxFiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each record is a little under a fifth of the maximum size, so a file is rotated after
+	// its sixth record
+	prompt := strings.Repeat("x", 200*1024)
+	for i := 0; i < records; i++ {
+		if err := l.Log(Record{RequestID: fmt.Sprint(i), Prompt: prompt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the current file holds the records written since the last rotation, and each backup
+	// the records before those in the newer files
+	wantFirst := map[string]int{
+		path:        24,
+		path + ".1": 18,
+		path + ".2": 12,
+	}
+	for file, first := range wantFirst {
+		got := readRecords(t, file)
+		want := records - first
+		if want > recordsPerFile {
+			want = recordsPerFile
+		}
+		if len(got) != want {
+			t.Errorf("%s: got %d records, want %d", file, len(got), want)
+			continue
+		}
+		if got[0].RequestID != fmt.Sprint(first) {
+			t.Errorf("%s: got first request %s, want %d", file, got[0].RequestID, first)
+		}
+	}
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != maxFiles {
+		t.Errorf("got rotated files %q, want %d", rotated, maxFiles)
+	}
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/jsonl"
+)
+
+const defaultTTL = time.Hour
+
+type entry struct {
+	Key      string            `json:"key"`
+	Response api.ModelResponse `json:"response"`
+	Expires  time.Time         `json:"expires"`
+}
+
+// Cache is a least recently used cache of model responses which expire after a TTL.  When
+// persisted, entries are appended to a JSON lines file which is compacted when loaded and
+// whenever it grows to twice the cache's size.
+type Cache struct {
+	maxEntries int
+	ttl        time.Duration
+	path       string
+
+	lock    sync.Mutex
+	entries *list.List
+	items   map[string]*list.Element
+	file    *jsonl.File
+}
+
+func NewCache(config api.CacheConfig) (*Cache, error) {
+	c := &Cache{
+		maxEntries: config.MaxEntries,
+		ttl:        config.TTL,
+		path:       config.File,
+		entries:    list.New(),
+		items:      make(map[string]*list.Element),
+	}
+	if c.maxEntries <= 0 {
+		return nil, fmt.Errorf("cache maxEntries must be positive")
+	}
+	if c.ttl == 0 {
+		c.ttl = defaultTTL
+	}
+	if c.path != "" {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+		if err := c.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Cache) Get(key string) (api.ModelResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, found := c.items[key]
+	if !found {
+		return api.ModelResponse{}, false
+	}
+	e := element.Value.(*entry)
+	if time.Now().After(e.Expires) {
+		c.remove(element)
+		return api.ModelResponse{}, false
+	}
+	c.entries.MoveToFront(element)
+	return e.Response, true
+}
+
+func (c *Cache) Add(key string, response api.ModelResponse) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e := &entry{Key: key, Response: response, Expires: time.Now().Add(c.ttl)}
+	c.insert(e)
+	if c.file == nil {
+		return
+	}
+	if c.file.Lines() >= 2*c.maxEntries {
+		if err := c.compact(); err != nil {
+			log.Errorf("failed to compact response cache %s: %v", c.path, err)
+		}
+		return
+	}
+	if err := c.file.Append(e); err != nil {
+		log.Errorf("failed to persist response cache entry to %s: %v", c.path, err)
+	}
+}
+
+func (c *Cache) insert(e *entry) {
+	if element, found := c.items[e.Key]; found {
+		element.Value = e
+		c.entries.MoveToFront(element)
+		return
+	}
+	c.items[e.Key] = c.entries.PushFront(e)
+	for c.entries.Len() > c.maxEntries {
+		c.remove(c.entries.Back())
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.entries.Remove(element)
+	delete(c.items, element.Value.(*entry).Key)
+}
+
+// load replays the entries in the cache file, skipping any which have expired.
+func (c *Cache) load() error {
+	now := time.Now()
+	return jsonl.Read(c.path, func(line int, data []byte) error {
+		e := &entry{}
+		if err := json.Unmarshal(data, e); err != nil {
+			// a partially written final entry is expected if the server was killed
+			log.Warnf("skipping invalid response cache entry on line %d of %s: %v", line, c.path, err)
+			return nil
+		}
+		if e.Expires.After(now) {
+			c.insert(e)
+		}
+		return nil
+	})
+}
+
+// compact rewrites the cache file with only the current entries, least recently used first
+// so that they are loaded in the same order.
+func (c *Cache) compact() error {
+	if c.file == nil {
+		file, err := jsonl.Open(c.path)
+		if err != nil {
+			return err
+		}
+		c.file = file
+	}
+	return c.file.Rewrite(func(encode func(v interface{}) error) error {
+		for element := c.entries.Back(); element != nil; element = element.Prev() {
+			if err := encode(element.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Cache) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,326 @@
+package conversation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/jsonl"
+)
+
+const (
+	defaultMaxTurns         = 10
+	defaultTTL              = 720 * time.Hour
+	defaultMaxConversations = 100
+
+	// expireInterval is how often expired conversations are removed from memory.
+	expireInterval = time.Minute
+
+	// minCompactEntries is the size below which the file is never compacted, so that stores
+	// holding few conversations are not rewritten after every change.
+	minCompactEntries = 1000
+)
+
+// Conversation is a user's conversation with the models.
+type Conversation struct {
+	ID       string        `json:"id"`
+	User     string        `json:"user"`
+	Created  time.Time     `json:"created"`
+	Updated  time.Time     `json:"updated"`
+	Messages []api.Message `json:"messages"`
+}
+
+// Summary describes a conversation without its messages.
+type Summary struct {
+	ID       string    `json:"id"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Messages int       `json:"messages"`
+	// Title is the start of the first message retained.
+	Title string `json:"title"`
+}
+
+// entry is a change to a conversation in the store's file.
+type entry struct {
+	Time           time.Time     `json:"time"`
+	User           string        `json:"user"`
+	ConversationID string        `json:"conversationId"`
+	Messages       []api.Message `json:"messages,omitempty"`
+	Deleted        bool          `json:"deleted,omitempty"`
+}
+
+// Store holds each user's conversations, keeping the most recent turns of each and the most
+// recently changed conversations of each user until they expire.  When persisted, changes are
+// appended to a JSON lines file which is compacted when loaded and whenever it grows to twice
+// the size of a compacted file.
+type Store struct {
+	maxMessages      int
+	maxConversations int
+	ttl              time.Duration
+	path             string
+	now              func() time.Time
+
+	lock sync.Mutex
+	// conversations is keyed by user and then conversation id
+	conversations map[string]map[string]*Conversation
+	// count is the number of conversations held
+	count   int
+	expired time.Time
+	file    *jsonl.File
+}
+
+func NewStore(config api.ConversationConfig) (*Store, error) {
+	return newStore(config, time.Now)
+}
+
+func newStore(config api.ConversationConfig, now func() time.Time) (*Store, error) {
+	maxTurns := config.MaxTurns
+	if maxTurns <= 0 {
+		maxTurns = defaultMaxTurns
+	}
+	maxConversations := config.MaxConversations
+	if maxConversations <= 0 {
+		maxConversations = defaultMaxConversations
+	}
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	s := &Store{
+		// a turn is the user's prompt and the model's response
+		maxMessages:      2 * maxTurns,
+		maxConversations: maxConversations,
+		ttl:              ttl,
+		path:             config.File,
+		now:              now,
+		conversations:    make(map[string]map[string]*Conversation),
+	}
+	if s.path != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Get returns a copy of the user's conversation.
+func (s *Store) Get(user, id string) (Conversation, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, found := s.conversations[user][id]
+	if !found || s.isExpired(c, s.now()) {
+		return Conversation{}, false
+	}
+	copied := *c
+	copied.Messages = append([]api.Message{}, c.Messages...)
+	return copied, true
+}
+
+// Append adds messages to the user's conversation, creating it if it does not exist.
+func (s *Store) Append(user, id string, messages ...api.Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now().UTC()
+	if now.Sub(s.expired) >= expireInterval {
+		s.expire(now)
+	}
+	e := entry{Time: now, User: user, ConversationID: id, Messages: messages}
+	s.apply(e)
+	return s.write(e)
+}
+
+// Delete removes the user's conversation, returning false if it did not exist.
+func (s *Store) Delete(user, id string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now().UTC()
+	if c, found := s.conversations[user][id]; !found || s.isExpired(c, now) {
+		return false, nil
+	}
+	e := entry{Time: now, User: user, ConversationID: id, Deleted: true}
+	s.apply(e)
+	return true, s.write(e)
+}
+
+// List summarizes the user's conversations, most recently updated first.
+func (s *Store) List(user string) []Summary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	summaries := []Summary{}
+	now := s.now()
+	for _, c := range s.conversations[user] {
+		if s.isExpired(c, now) {
+			continue
+		}
+		summary := Summary{
+			ID:       c.ID,
+			Created:  c.Created,
+			Updated:  c.Updated,
+			Messages: len(c.Messages),
+		}
+		if len(c.Messages) > 0 {
+			summary.Title = title(c.Messages[0].Content)
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Updated.After(summaries[j].Updated)
+	})
+	return summaries
+}
+
+func title(content string) string {
+	const maxTitle = 80
+	runes := []rune(content)
+	if len(runes) <= maxTitle {
+		return content
+	}
+	return string(runes[:maxTitle]) + "..."
+}
+
+func (s *Store) isExpired(c *Conversation, now time.Time) bool {
+	return now.Sub(c.Updated) >= s.ttl
+}
+
+// apply applies the change to the in memory conversations.  The caller must hold the lock.
+func (s *Store) apply(e entry) {
+	if e.Deleted {
+		s.remove(e.User, e.ConversationID)
+		return
+	}
+	c, found := s.conversations[e.User][e.ConversationID]
+	if found && s.isExpired(c, e.Time) {
+		// the conversation expired before this change, so it starts again
+		c.Created, c.Messages = e.Time, nil
+	}
+	if !found {
+		if len(s.conversations[e.User]) >= s.maxConversations {
+			s.removeOldest(e.User)
+		}
+		users, found := s.conversations[e.User]
+		if !found {
+			users = make(map[string]*Conversation)
+			s.conversations[e.User] = users
+		}
+		c = &Conversation{ID: e.ConversationID, User: e.User, Created: e.Time}
+		users[e.ConversationID] = c
+		s.count++
+	}
+	c.Updated = e.Time
+	c.Messages = append(c.Messages, e.Messages...)
+	if len(c.Messages) > s.maxMessages {
+		c.Messages = append([]api.Message{}, c.Messages[len(c.Messages)-s.maxMessages:]...)
+	}
+}
+
+// remove removes the user's conversation, if it exists.  The caller must hold the lock.
+func (s *Store) remove(user, id string) {
+	if _, found := s.conversations[user][id]; found {
+		s.count--
+	}
+	delete(s.conversations[user], id)
+	if len(s.conversations[user]) == 0 {
+		delete(s.conversations, user)
+	}
+}
+
+// removeOldest removes the user's least recently changed conversation.  The caller must hold
+// the lock.
+func (s *Store) removeOldest(user string) {
+	var oldest *Conversation
+	for _, c := range s.conversations[user] {
+		if oldest == nil || c.Updated.Before(oldest.Updated) {
+			oldest = c
+		}
+	}
+	if oldest != nil {
+		s.remove(user, oldest.ID)
+	}
+}
+
+// expire removes the conversations which have expired.  The caller must hold the lock.
+func (s *Store) expire(now time.Time) {
+	for user, users := range s.conversations {
+		for id, c := range users {
+			if s.isExpired(c, now) {
+				s.remove(user, id)
+			}
+		}
+	}
+	s.expired = now
+}
+
+// write appends the change to the file, if the store is persisted, compacting it instead
+// if it has grown to twice its compacted size.  The caller must hold the lock.
+func (s *Store) write(e entry) error {
+	if s.file == nil {
+		return nil
+	}
+	// a compacted file holds two entries for each conversation
+	if n := s.file.Lines(); n >= minCompactEntries && n >= 4*s.count {
+		return s.compact()
+	}
+	return s.file.Append(e)
+}
+
+func (s *Store) load() error {
+	return jsonl.Read(s.path, func(line int, data []byte) error {
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			// a partially written final entry is expected if the server was killed
+			log.Warnf("skipping invalid conversation entry on line %d of %s: %v", line, s.path, err)
+			return nil
+		}
+		s.apply(e)
+		return nil
+	})
+}
+
+// compact rewrites the file with two entries for each conversation which has not expired.
+// The caller must hold the lock.
+func (s *Store) compact() error {
+	s.expire(s.now().UTC())
+	if s.file == nil {
+		file, err := jsonl.Open(s.path)
+		if err != nil {
+			return err
+		}
+		s.file = file
+	}
+	err := s.file.Rewrite(func(encode func(v interface{}) error) error {
+		for _, users := range s.conversations {
+			for _, c := range users {
+				// the creation time is only recorded by a conversation's first entry, so it
+				// is written before the entry holding the messages
+				if err := encode(entry{Time: c.Created, User: c.User, ConversationID: c.ID}); err != nil {
+					return err
+				}
+				if err := encode(entry{Time: c.Updated, User: c.User, ConversationID: c.ID, Messages: c.Messages}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compact conversation store %s: %v", s.path, err)
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,143 @@
+package conversation
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+func TestStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.jsonl")
+	s, err := NewStore(api.ConversationConfig{File: path, MaxTurns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := api.Message{Role: api.RoleUser, Content: "hello"}
+	for i := 0; i < 3*minCompactEntries; i++ {
+		if err := s.Append("alice", fmt.Sprintf("c%d", i%10), message); err != nil {
+			t.Fatal(err)
+		}
+		if n := s.file.Lines(); n > minCompactEntries {
+			t.Fatalf("after %d appends the file holds %d entries, want at most %d", i+1, n, minCompactEntries)
+		}
+	}
+	if _, err := s.Delete("alice", "c0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStore(api.ConversationConfig{File: path, MaxTurns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := len(s.List("alice")); got != 9 {
+		t.Errorf("reloaded %d conversations, want 9", got)
+	}
+	if c, _ := s.Get("alice", "c1"); len(c.Messages) != 2 {
+		t.Errorf("reloaded %d messages, want 2", len(c.Messages))
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	config := api.ConversationConfig{TTL: time.Hour, MaxConversations: 2}
+
+	// step appends a message to a conversation after advancing the clock
+	type step struct {
+		advance time.Duration
+		id      string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		// advance moves the clock after the steps
+		advance time.Duration
+		// want is the number of messages in each conversation listed
+		want map[string]int
+	}{
+		{
+			name:    "conversations are kept until the ttl",
+			steps:   []step{{id: "c1"}, {advance: time.Minute, id: "c2"}},
+			advance: time.Hour - time.Second,
+			want:    map[string]int{"c2": 1},
+		},
+		{
+			name:    "conversations expire after the ttl",
+			steps:   []step{{id: "c1"}, {id: "c2"}},
+			advance: time.Hour,
+			want:    map[string]int{},
+		},
+		{
+			name:    "changes extend the ttl",
+			steps:   []step{{id: "c1"}, {advance: 30 * time.Minute, id: "c1"}},
+			advance: 45 * time.Minute,
+			want:    map[string]int{"c1": 2},
+		},
+		{
+			name:  "an expired conversation starts again",
+			steps: []step{{id: "c1"}, {advance: time.Hour, id: "c1"}},
+			want:  map[string]int{"c1": 1},
+		},
+		{
+			name:  "the least recently changed conversation is removed",
+			steps: []step{{id: "c1"}, {advance: time.Second, id: "c2"}, {advance: time.Second, id: "c1"}, {advance: time.Second, id: "c3"}},
+			want:  map[string]int{"c1": 2, "c3": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := config
+			config.File = filepath.Join(t.TempDir(), "conversations.jsonl")
+			clock := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+			now := func() time.Time { return clock }
+			s, err := newStore(config, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, step := range tt.steps {
+				clock = clock.Add(step.advance)
+				if err := s.Append("alice", step.id, api.Message{Role: api.RoleUser, Content: "hello"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			clock = clock.Add(tt.advance)
+			check := func(s *Store) {
+				t.Helper()
+				got := map[string]int{}
+				for _, summary := range s.List("alice") {
+					got[summary.ID] = summary.Messages
+					if c, found := s.Get("alice", summary.ID); !found || len(c.Messages) != summary.Messages {
+						t.Errorf("got conversation %s with %d messages, want %d", summary.ID, len(c.Messages), summary.Messages)
+					}
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got conversations %v, want %v", got, tt.want)
+				}
+			}
+			check(s)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// expired and removed conversations are dropped when compacting the reloaded file
+			s, err = newStore(config, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			check(s)
+			if s.count != len(tt.want) {
+				t.Errorf("holding %d conversations, want %d", s.count, len(tt.want))
+			}
+			if n := s.file.Lines(); n != 2*len(tt.want) {
+				t.Errorf("compacted file holds %d entries, want %d", n, 2*len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package feedback
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/jsonl"
+)
+
+// Feedback is a user's assessment of a response.
+type Feedback struct {
+	Time              time.Time `json:"time"`
+	RequestID         string    `json:"requestId"`
+	User              string    `json:"user"`
+	Model             string    `json:"model"`
+	ConversationID    string    `json:"conversationId,omitempty"`
+	Response          string    `json:"response,omitempty"`
+	ResponseAccepted  bool      `json:"responseAccepted"`
+	CorrectedResponse string    `json:"correctedResponse,omitempty"`
+	UserComments      string    `json:"userComments,omitempty"`
+}
+
+// Filter selects the feedback listed.  Empty fields match everything.
+type Filter struct {
+	From  time.Time
+	To    time.Time
+	User  string
+	Model string
+}
+
+func (f Filter) matches(fb Feedback) bool {
+	if !f.From.IsZero() && fb.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !fb.Time.Before(f.To) {
+		return false
+	}
+	if f.User != "" && fb.User != f.User {
+		return false
+	}
+	if f.Model != "" && fb.Model != f.Model {
+		return false
+	}
+	return true
+}
+
+// Store persists feedback.
+type Store interface {
+	Add(fb Feedback) error
+	// List returns the feedback matching filter in the order it was added.
+	List(filter Filter) ([]Feedback, error)
+}
+
+// MemoryStore holds feedback in memory, it is lost when the server restarts.
+type MemoryStore struct {
+	lock     sync.Mutex
+	feedback []Feedback
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Add(fb Feedback) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.feedback = append(s.feedback, fb)
+	return nil
+}
+
+func (s *MemoryStore) List(filter Filter) ([]Feedback, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := []Feedback{}
+	for _, fb := range s.feedback {
+		if filter.matches(fb) {
+			result = append(result, fb)
+		}
+	}
+	return result, nil
+}
+
+// FileStore appends feedback to a JSON lines file.
+type FileStore struct {
+	path string
+	file *jsonl.File
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	file, err := jsonl.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open feedback store %s: %v", path, err)
+	}
+	return &FileStore{path: path, file: file}, nil
+}
+
+func (s *FileStore) Add(fb Feedback) error {
+	return s.file.Append(fb)
+}
+
+func (s *FileStore) List(filter Filter) ([]Feedback, error) {
+	result := []Feedback{}
+	err := jsonl.Read(s.path, func(line int, data []byte) error {
+		var fb Feedback
+		if err := json.Unmarshal(data, &fb); err != nil {
+			return fmt.Errorf("invalid feedback on line %d of %s: %v", line, s.path, err)
+		}
+		if filter.matches(fb) {
+			result = append(result, fb)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *FileStore) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,58 @@
+package feedback
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultMaxResponses = 10000
+
+// Response identifies a response served to a user.
+type Response struct {
+	User           string
+	Model          string
+	ConversationID string
+	Time           time.Time
+}
+
+// Responses remembers the most recently served responses so that feedback can be
+// validated against them.
+type Responses struct {
+	max int
+
+	lock      sync.Mutex
+	responses map[string]Response
+	// order holds the request ids in the order they were added, as a ring buffer
+	order []string
+	next  int
+}
+
+// NewResponses remembers up to max responses, defaulting to 10000.
+func NewResponses(max int) *Responses {
+	if max <= 0 {
+		max = defaultMaxResponses
+	}
+	return &Responses{
+		max:       max,
+		responses: make(map[string]Response),
+		order:     make([]string, max),
+	}
+}
+
+func (r *Responses) Add(requestID string, response Response) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if oldest := r.order[r.next]; oldest != "" {
+		delete(r.responses, oldest)
+	}
+	r.order[r.next] = requestID
+	r.next = (r.next + 1) % r.max
+	r.responses[requestID] = response
+}
+
+func (r *Responses) Get(requestID string) (Response, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	response, found := r.responses[requestID]
+	return response, found
+}
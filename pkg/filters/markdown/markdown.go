@@ -1,6 +1,7 @@
 package markdown
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 
@@ -14,7 +15,7 @@ var (
 	markdownRegex = regexp.MustCompile("(?s)`{3}.*?\n(.*)`{3}")
 )
 
-func MarkdownStripper(response api.ModelResponse) (api.ModelResponse, error) {
+func MarkdownStripper(ctx context.Context, response api.ModelResponse) (api.ModelResponse, error) {
 
 	if response.Output == "" {
 		return response, fmt.Errorf("response output is empty")
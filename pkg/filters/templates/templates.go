@@ -0,0 +1,170 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// promptVariable is the name under which templates are given the request's prompt.
+const promptVariable = "Prompt"
+
+// Library holds the named prompt templates loaded from the config.
+type Library struct {
+	templates map[string]*promptTemplate
+}
+
+type promptTemplate struct {
+	name      string
+	template  *template.Template
+	variables map[string]api.TemplateVariable
+}
+
+// NewLibrary parses and validates the configured templates.  Templates may only refer to
+// the prompt and their declared variables.
+func NewLibrary(configs []api.PromptTemplateConfig) (*Library, error) {
+	l := &Library{templates: map[string]*promptTemplate{}}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("prompt template name is required")
+		}
+		if _, found := l.templates[config.Name]; found {
+			return nil, fmt.Errorf("prompt template %q is configured more than once", config.Name)
+		}
+		t := &promptTemplate{
+			name:      config.Name,
+			variables: map[string]api.TemplateVariable{},
+		}
+		for _, v := range config.Variables {
+			if v.Name == "" || v.Name == promptVariable {
+				return nil, fmt.Errorf("prompt template %q: invalid variable name %q", config.Name, v.Name)
+			}
+			if _, found := t.variables[v.Name]; found {
+				return nil, fmt.Errorf("prompt template %q: variable %q is declared more than once", config.Name, v.Name)
+			}
+			t.variables[v.Name] = v
+		}
+		parsed, err := template.New(config.Name).Option("missingkey=error").Parse(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt template %q: %v", config.Name, err)
+		}
+		t.template = parsed
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("invalid prompt template %q: %v", config.Name, err)
+		}
+		l.templates[config.Name] = t
+	}
+	return l, nil
+}
+
+// Names returns the names of the templates in the library.
+func (l *Library) Names() []string {
+	names := []string{}
+	for name := range l.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has returns true if the library holds the named template.
+func (l *Library) Has(name string) bool {
+	_, found := l.templates[name]
+	return found
+}
+
+// Render renders the named template with the input's prompt and variables.
+func (l *Library) Render(name string, input api.ModelInput) (string, error) {
+	t, found := l.templates[name]
+	if !found {
+		return "", api.NewError(api.ErrorCodeBadRequest, nil, "unknown prompt template %q, valid templates: %q", name, l.Names())
+	}
+	data := map[string]string{promptVariable: input.Prompt}
+	for name, value := range input.Variables {
+		if _, declared := t.variables[name]; !declared {
+			return "", api.NewError(api.ErrorCodeBadRequest, nil, "prompt template %q has no variable %q", t.name, name)
+		}
+		data[name] = value
+	}
+	return t.render(data)
+}
+
+// validate renders the template with every variable empty and then with every variable
+// set, so that references to undeclared variables fail whichever branches they are in.
+func (t *promptTemplate) validate() error {
+	for _, value := range []string{"", "x"} {
+		data := map[string]string{promptVariable: value}
+		for name := range t.variables {
+			data[name] = value
+		}
+		if _, err := t.render(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render executes the template, filling in defaults for the variables missing from data.
+func (t *promptTemplate) render(data map[string]string) (string, error) {
+	missing := []string{}
+	for name, v := range t.variables {
+		if _, found := data[name]; found {
+			continue
+		}
+		if v.Required {
+			missing = append(missing, name)
+		}
+		data[name] = v.Default
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", api.NewError(api.ErrorCodeBadRequest, nil, "prompt template %q requires variables %q", t.name, missing)
+	}
+	out := strings.Builder{}
+	if err := t.template.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Renderer is an input filter rendering the prompt with the template named by the input,
+// or with its default template.  Inputs which name no template, for models without a
+// default template, are passed through unchanged.
+type Renderer struct {
+	library         *Library
+	defaultTemplate string
+}
+
+// NewRenderer returns a renderer using the library, failing if the default template is not in it.
+func NewRenderer(library *Library, defaultTemplate string) (*Renderer, error) {
+	if defaultTemplate != "" && !library.Has(defaultTemplate) {
+		return nil, fmt.Errorf("unknown default prompt template %q, valid templates: %q", defaultTemplate, library.Names())
+	}
+	return &Renderer{library: library, defaultTemplate: defaultTemplate}, nil
+}
+
+func (r *Renderer) RenderPrompt(ctx context.Context, input api.ModelInput) (api.ModelInput, error) {
+	name := input.Template
+	if name == "" {
+		name = r.defaultTemplate
+	}
+	if name == "" {
+		if len(input.Variables) > 0 {
+			return input, api.NewError(api.ErrorCodeBadRequest, nil, "variables require a prompt template")
+		}
+		return input, nil
+	}
+	prompt, err := r.library.Render(name, input)
+	if err != nil {
+		return input, err
+	}
+	log.Debugf("rendered prompt template %s:\n%s", name, prompt)
+	input.Prompt = prompt
+	return input, nil
+}
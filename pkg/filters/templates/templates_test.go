@@ -0,0 +1,174 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+var configs = []api.PromptTemplateConfig{
+	{
+		Name:     "task",
+		Template: "Write an Ansible task in {{.language}} to {{.Prompt}}",
+		Variables: []api.TemplateVariable{
+			{Name: "language", Default: "YAML"},
+		},
+	},
+	{
+		Name:     "resource",
+		Template: "Create a {{.kind}} in {{.namespace}}{{if .labels}} labelled {{.labels}}{{end}}: {{.Prompt}}",
+		Variables: []api.TemplateVariable{
+			{Name: "kind", Required: true},
+			{Name: "namespace", Default: "default"},
+			{Name: "labels"},
+		},
+	},
+	{
+		Name:     "optional",
+		Template: "{{if .kind}}Create a {{.kind}}: {{end}}{{.Prompt}}",
+		Variables: []api.TemplateVariable{
+			{Name: "kind"},
+		},
+	},
+}
+
+func TestRenderPrompt(t *testing.T) {
+	library, err := NewLibrary(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name            string
+		defaultTemplate string
+		input           api.ModelInput
+		want            string
+		// wantErr is the code of the error expected, if any
+		wantErr api.ErrorCode
+	}{
+		{
+			name:  "renders the prompt and variables",
+			input: api.ModelInput{Prompt: "install nginx", Template: "task", Variables: map[string]string{"language": "JSON"}},
+			want:  "Write an Ansible task in JSON to install nginx",
+		},
+		{
+			name:  "variables take their defaults",
+			input: api.ModelInput{Prompt: "install nginx", Template: "task"},
+			want:  "Write an Ansible task in YAML to install nginx",
+		},
+		{
+			name:  "required variables are rendered",
+			input: api.ModelInput{Prompt: "serve nginx", Template: "resource", Variables: map[string]string{"kind": "Deployment", "labels": "app=nginx"}},
+			want:  "Create a Deployment in default labelled app=nginx: serve nginx",
+		},
+		{
+			name:  "unset optional variables are false",
+			input: api.ModelInput{Prompt: "serve nginx", Template: "optional"},
+			want:  "serve nginx",
+		},
+		{
+			name:  "set optional variables are true",
+			input: api.ModelInput{Prompt: "serve nginx", Template: "optional", Variables: map[string]string{"kind": "Pod"}},
+			want:  "Create a Pod: serve nginx",
+		},
+		{
+			name:            "inputs without a template use the default template",
+			defaultTemplate: "task",
+			input:           api.ModelInput{Prompt: "install nginx"},
+			want:            "Write an Ansible task in YAML to install nginx",
+		},
+		{
+			name:  "inputs without a template or a default template are unchanged",
+			input: api.ModelInput{Prompt: "install nginx"},
+			want:  "install nginx",
+		},
+		{
+			name:    "missing required variables are rejected",
+			input:   api.ModelInput{Prompt: "serve nginx", Template: "resource"},
+			wantErr: api.ErrorCodeBadRequest,
+		},
+		{
+			name:    "undeclared variables are rejected",
+			input:   api.ModelInput{Prompt: "install nginx", Template: "task", Variables: map[string]string{"version": "2"}},
+			wantErr: api.ErrorCodeBadRequest,
+		},
+		{
+			name:    "unknown templates are rejected",
+			input:   api.ModelInput{Prompt: "install nginx", Template: "unknown"},
+			wantErr: api.ErrorCodeBadRequest,
+		},
+		{
+			name:    "variables without a template are rejected",
+			input:   api.ModelInput{Prompt: "install nginx", Variables: map[string]string{"language": "JSON"}},
+			wantErr: api.ErrorCodeBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRenderer(library, tt.defaultTemplate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.RenderPrompt(context.Background(), tt.input)
+			if tt.wantErr != "" {
+				var apiErr *api.Error
+				if !errors.As(err, &apiErr) || apiErr.Code != tt.wantErr {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Prompt != tt.want {
+				t.Errorf("got prompt %q, want %q", got.Prompt, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLibrary(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  api.PromptTemplateConfig
+		wantErr bool
+	}{
+		{
+			name:   "valid template",
+			config: api.PromptTemplateConfig{Name: "valid", Template: "{{.Prompt}} in {{.language}}", Variables: []api.TemplateVariable{{Name: "language"}}},
+		},
+		{
+			name:    "undeclared variable",
+			config:  api.PromptTemplateConfig{Name: "undeclared", Template: "{{.Prompt}} in {{.language}}"},
+			wantErr: true,
+		},
+		{
+			name:    "undeclared variable in a conditional branch",
+			config:  api.PromptTemplateConfig{Name: "branch", Template: "{{if .kind}}{{.language}}{{end}}{{.Prompt}}", Variables: []api.TemplateVariable{{Name: "kind"}}},
+			wantErr: true,
+		},
+		{
+			name:    "variable named Prompt",
+			config:  api.PromptTemplateConfig{Name: "prompt", Template: "{{.Prompt}}", Variables: []api.TemplateVariable{{Name: "Prompt"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid syntax",
+			config:  api.PromptTemplateConfig{Name: "syntax", Template: "{{.Prompt"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLibrary([]api.PromptTemplateConfig{tt.config})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewRenderer(&Library{}, "unknown"); err == nil {
+		t.Error("got no error for an unknown default template")
+	}
+}
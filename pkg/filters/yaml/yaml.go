@@ -1,6 +1,7 @@
 package yaml
 
 import (
+	"context"
 	"fmt"
 
 	"gopkg.in/yaml.v2"
@@ -10,7 +11,7 @@ import (
 	"github.com/openshift/wisdom/pkg/api"
 )
 
-func YamlLinter(response api.ModelResponse) (api.ModelResponse, error) {
+func YamlLinter(ctx context.Context, response api.ModelResponse) (api.ModelResponse, error) {
 	if err := isValidYAML(response.Output); err != nil {
 		return response, fmt.Errorf("response output is not valid YAML: %s", err)
 	}
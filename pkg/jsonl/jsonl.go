@@ -0,0 +1,145 @@
+package jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// maxLineSize is the longest line read, large enough for responses with long contexts.
+const maxLineSize = 16 * 1024 * 1024
+
+// File appends JSON values to a JSON lines file, one per line.
+type File struct {
+	path string
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+	// lines is the number of values written since the file was opened or rewritten
+	lines int
+}
+
+// Open opens the file at path for appending, creating it if it does not exist.
+func Open(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Append writes v as a line at the end of the file.
+func (f *File) Append(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, err := f.file.Write(buf)
+	f.size += int64(n)
+	f.lines++
+	return err
+}
+
+// Size returns the size of the file in bytes.
+func (f *File) Size() int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.size
+}
+
+// Lines returns the number of values written since the file was opened or last rewritten.
+func (f *File) Lines() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.lines
+}
+
+// Rewrite replaces the content of the file with the values passed to encode by write.
+// The new content is written to a temporary file which is renamed over the file, so the
+// file is left unchanged if writing fails.
+func (f *File) Rewrite(write func(encode func(v interface{}) error) error) error {
+	tmp := f.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	lines := 0
+	err = write(func(v interface{}) error {
+		lines++
+		return encoder.Encode(v)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+	f.file.Close()
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.lines = lines
+	return nil
+}
+
+// Close closes the file, after which it must not be appended to.
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.file.Close()
+}
+
+// Read calls fn with each line of the file at path, numbered from 1, stopping at the first
+// error fn returns.  A file which does not exist is read as empty.
+func Read(path string, fn func(line int, data []byte) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if err := fn(line, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
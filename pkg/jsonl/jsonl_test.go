@@ -0,0 +1,84 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type record struct {
+	N int `json:"n"`
+}
+
+func readAll(t *testing.T, path string) []record {
+	t.Helper()
+	records := []record{}
+	err := Read(path, func(line int, data []byte) error {
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			t.Fatalf("line %d: %v", line, err)
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.jsonl")
+	if got := readAll(t, path); len(got) != 0 {
+		t.Fatalf("missing file: got %v, want no records", got)
+	}
+
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := f.Append(record{N: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := readAll(t, path), []record{{1}, {2}, {3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after append: got %v, want %v", got, want)
+	}
+	if f.Lines() != 3 || f.Size() != int64(len(`{"n":1}`+"\n")*3) {
+		t.Errorf("after append: got %d lines of %d bytes", f.Lines(), f.Size())
+	}
+
+	err = f.Rewrite(func(encode func(v interface{}) error) error {
+		return encode(record{N: 3})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Append(record{N: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, path), []record{{3}, {4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after rewrite: got %v, want %v", got, want)
+	}
+	if f.Lines() != 2 {
+		t.Errorf("after rewrite: got %d lines, want 2", f.Lines())
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening appends to the existing content
+	f, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Append(record{N: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, path), []record{{3}, {4}, {5}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopen: got %v, want %v", got, want)
+	}
+}
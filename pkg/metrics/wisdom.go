@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The metrics are registered with the default registry, which also holds the Go runtime and
+// process collectors.
+
+// latencyBuckets, in seconds, cover both fast cached responses and slow generations.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+var (
+	// Requests counts inference requests by the model which served them and the error code,
+	// or "ok".
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wisdom_requests_total",
+		Help: "Inference requests by model and result code.",
+	}, []string{"model", "code"})
+	// RequestDuration is the time taken to serve inference requests, including filters,
+	// retries and fallbacks.
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wisdom_request_duration_seconds",
+		Help:    "Time taken to serve inference requests by model.",
+		Buckets: latencyBuckets,
+	}, []string{"model"})
+	// RejectedRequests counts inference requests refused before any model was invoked.
+	RejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wisdom_rejected_requests_total",
+		Help: "Inference requests rejected before invoking a model by error code.",
+	}, []string{"code"})
+
+	// UpstreamRequests counts invocations of model providers by result code.
+	UpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wisdom_upstream_requests_total",
+		Help: "Model provider invocations by model and result code.",
+	}, []string{"model", "code"})
+	// UpstreamDuration is the time taken by model providers to respond.
+	UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wisdom_upstream_duration_seconds",
+		Help:    "Time taken by model providers to respond by model.",
+		Buckets: latencyBuckets,
+	}, []string{"model"})
+
+	// FilterRejections counts inputs and responses rejected by each filter.
+	FilterRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wisdom_filter_rejections_total",
+		Help: "Inputs and responses rejected by filters by model and filter.",
+	}, []string{"model", "filter"})
+
+	// Tokens counts tokens used by each model, by type prompt or completion.
+	Tokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wisdom_tokens_total",
+		Help: "Tokens used by model and type.",
+	}, []string{"model", "type"})
+
+	// CacheRequests counts response cache lookups by result hit or miss.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wisdom_cache_requests_total",
+		Help: "Response cache lookups by model and result.",
+	}, []string{"model", "result"})
+
+	// AuthFailures counts requests without a valid bearer token for an allowed user.
+	AuthFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wisdom_auth_failures_total",
+		Help: "Requests which failed bearer token authentication.",
+	})
+)
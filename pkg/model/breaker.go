@@ -0,0 +1,211 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+const (
+	defaultBreakerMinRequests  = 5
+	defaultBreakerWindow       = time.Minute
+	defaultBreakerOpenDuration = 30 * time.Second
+)
+
+type BreakerState string
+
+const (
+	// BreakerClosed passes all invocations through to the model.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails all invocations without calling the model.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen allows a single probe invocation to determine whether the model has recovered.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Breaker is a circuit breaker around a model.  It opens when the fraction of failed
+// invocations within a window exceeds the configured failure rate, failing invocations
+// fast with api.ErrModelUnavailable until the open duration has passed.  It then allows
+// a single probe invocation through, closing again if the probe succeeds.
+type Breaker struct {
+	api.Model
+	name   string
+	config api.CircuitBreakerConfig
+	// now returns the current time, it is replaced by tests
+	now func() time.Time
+
+	lock        sync.Mutex
+	state       BreakerState
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	probing     bool
+}
+
+func NewBreaker(name string, m api.Model, config api.CircuitBreakerConfig) *Breaker {
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaultBreakerMinRequests
+	}
+	if config.Window <= 0 {
+		config.Window = defaultBreakerWindow
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = defaultBreakerOpenDuration
+	}
+	return &Breaker{
+		Model:       m,
+		name:        name,
+		config:      config,
+		now:         time.Now,
+		state:       BreakerClosed,
+		windowStart: time.Now(),
+	}
+}
+
+func (b *Breaker) Invoke(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+	response, err := b.Model.Invoke(ctx, input)
+	b.record(ctx, probe, err)
+	return response, err
+}
+
+func (b *Breaker) InvokeStream(ctx context.Context, input api.ModelInput, onToken func(string) error) (api.ModelResponse, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+	// a failure to write the tokens to the client, e.g. because it disconnected, is not a
+	// failure of the model, which was generating them
+	var tokenErr error
+	write := func(token string) error {
+		if err := onToken(token); err != nil {
+			tokenErr = err
+			return err
+		}
+		return nil
+	}
+	var response api.ModelResponse
+	if sm, ok := b.Model.(api.StreamingModel); ok {
+		response, err = sm.InvokeStream(ctx, input, write)
+	} else {
+		response, err = b.Model.Invoke(ctx, input)
+		if err == nil {
+			err = write(response.Output)
+		}
+	}
+	if tokenErr != nil {
+		b.record(ctx, probe, nil)
+	} else {
+		b.record(ctx, probe, err)
+	}
+	return response, err
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.config.OpenDuration {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// allow returns an error if the invocation should fail fast, and whether the invocation
+// is the probe of a half open breaker.
+func (b *Breaker) allow() (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.config.OpenDuration {
+		b.state = BreakerHalfOpen
+	}
+	switch b.state {
+	case BreakerOpen:
+		return false, api.NewError(api.ErrorCodeModelUnavailable, nil, "circuit breaker for %s is open", b.name)
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, api.NewError(api.ErrorCodeModelUnavailable, nil, "circuit breaker for %s is half-open", b.name)
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *Breaker) record(ctx context.Context, probe bool, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if err != nil && ctx.Err() != nil {
+		// the caller gave up, which says nothing about the health of the model
+		return
+	}
+	// rejected requests show the model is reachable, they are not failures of the model
+	failed := err != nil && !errors.Is(err, api.ErrBadRequest) && !errors.Is(err, api.ErrAuthFailed)
+
+	if probe {
+		if failed {
+			log.Warnf("circuit breaker for %s probe failed, reopening: %v", b.name, err)
+			b.open()
+		} else {
+			log.Infof("circuit breaker for %s probe succeeded, closing", b.name)
+			b.state = BreakerClosed
+			b.reset()
+		}
+		return
+	}
+	if b.state != BreakerClosed {
+		return
+	}
+
+	if b.now().Sub(b.windowStart) >= b.config.Window {
+		b.reset()
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.config.MinRequests && float64(b.failures)/float64(b.requests) >= b.config.FailureRate {
+		log.Warnf("circuit breaker for %s opening after %d of %d invocations failed", b.name, b.failures, b.requests)
+		b.open()
+	}
+}
+
+func (b *Breaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.reset()
+}
+
+func (b *Breaker) reset() {
+	b.windowStart = b.now()
+	b.requests = 0
+	b.failures = 0
+}
+
+// BreakerStates returns the state of each model's circuit breaker.  Models without a
+// circuit breaker are always reported as closed.
+func BreakerStates(models map[string]api.Model) map[string]BreakerState {
+	states := map[string]BreakerState{}
+	for name, m := range models {
+		if b, ok := m.(*Breaker); ok {
+			states[name] = b.State()
+		} else {
+			states[name] = BreakerClosed
+		}
+	}
+	return states
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// stubModel returns err from each invocation.
+type stubModel struct {
+	err   error
+	calls int
+}
+
+func (m *stubModel) Invoke(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
+	m.calls++
+	return api.ModelResponse{Output: "ok"}, m.err
+}
+
+func (m *stubModel) GetFilter() api.Filter {
+	return api.NewFilter(nil, nil)
+}
+
+// fakeClock is a time which only moves when advanced.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+var (
+	errUpstream = api.NewError(api.ErrorCodeUpstreamUnavailable, nil, "upstream failed")
+	errRejected = api.NewError(api.ErrorCodeBadRequest, nil, "invalid request")
+)
+
+func TestBreaker(t *testing.T) {
+	config := api.CircuitBreakerConfig{FailureRate: 0.5, MinRequests: 4, Window: time.Minute, OpenDuration: 30 * time.Second}
+
+	// step is an invocation of the breaker after advancing the clock
+	type step struct {
+		advance time.Duration
+		// err is returned by the model, if it is invoked
+		err error
+		// cancel invokes the breaker with a cancelled context
+		cancel     bool
+		wantCalled bool
+		wantState  BreakerState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens at the failure rate after the minimum requests",
+			steps: []step{
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerOpen},
+				{err: nil, wantCalled: false, wantState: BreakerOpen},
+			},
+		},
+		{
+			name: "stays closed below the failure rate",
+			steps: []step{
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "failures in an earlier window are forgotten",
+			steps: []step{
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{advance: time.Minute, err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "rejected and abandoned requests are not failures",
+			steps: []step{
+				{err: errRejected, wantCalled: true, wantState: BreakerClosed},
+				{err: errRejected, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, cancel: true, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, cancel: true, wantCalled: true, wantState: BreakerClosed},
+				{err: nil, wantCalled: true, wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "successful probe closes the breaker",
+			steps: []step{
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerOpen},
+				{advance: 29 * time.Second, wantCalled: false, wantState: BreakerOpen},
+				{advance: time.Second, err: nil, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "failed probe reopens the breaker",
+			steps: []step{
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerClosed},
+				{err: errUpstream, wantCalled: true, wantState: BreakerOpen},
+				{advance: 30 * time.Second, err: errUpstream, wantCalled: true, wantState: BreakerOpen},
+				{advance: 29 * time.Second, wantCalled: false, wantState: BreakerOpen},
+				{advance: time.Second, err: nil, wantCalled: true, wantState: BreakerClosed},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+			m := &stubModel{}
+			b := NewBreaker("test/model", m, config)
+			b.now = clock.now
+			b.windowStart = clock.now()
+
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				m.err = s.err
+				calls := m.calls
+				ctx, cancel := context.WithCancel(context.Background())
+				if s.cancel {
+					cancel()
+				}
+				_, err := b.Invoke(ctx, api.ModelInput{})
+				cancel()
+
+				if called := m.calls > calls; called != s.wantCalled {
+					t.Errorf("step %d: got model called %v, want %v", i, called, s.wantCalled)
+				}
+				if !s.wantCalled && !errors.Is(err, api.ErrModelUnavailable) {
+					t.Errorf("step %d: got error %v, want model_unavailable", i, err)
+				}
+				if state := b.State(); state != s.wantState {
+					t.Errorf("step %d: got state %s, want %s", i, state, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+// stubStreamingModel streams its output as a single token, returning the error from onToken.
+type stubStreamingModel struct {
+	stubModel
+}
+
+func (m *stubStreamingModel) InvokeStream(ctx context.Context, input api.ModelInput, onToken func(string) error) (api.ModelResponse, error) {
+	response, err := m.Invoke(ctx, input)
+	if err != nil {
+		return response, err
+	}
+	return response, onToken(response.Output)
+}
+
+func TestBreakerStreamWriteErrors(t *testing.T) {
+	config := api.CircuitBreakerConfig{FailureRate: 0.5, MinRequests: 2}
+	errDisconnected := errors.New("client disconnected")
+	tests := []struct {
+		name      string
+		model     api.Model
+		modelErr  error
+		onToken   func(string) error
+		wantState BreakerState
+	}{
+		{
+			name:      "streaming model write errors are not failures",
+			model:     &stubStreamingModel{},
+			onToken:   func(string) error { return errDisconnected },
+			wantState: BreakerClosed,
+		},
+		{
+			name:      "non-streaming model write errors are not failures",
+			model:     &stubModel{},
+			onToken:   func(string) error { return errDisconnected },
+			wantState: BreakerClosed,
+		},
+		{
+			name:      "provider errors are failures",
+			model:     &stubStreamingModel{},
+			modelErr:  errUpstream,
+			onToken:   func(string) error { return nil },
+			wantState: BreakerOpen,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			switch m := tt.model.(type) {
+			case *stubModel:
+				m.err = tt.modelErr
+			case *stubStreamingModel:
+				m.err = tt.modelErr
+			}
+			b := NewBreaker("test/model", tt.model, config)
+			for i := 0; i < config.MinRequests; i++ {
+				if _, err := b.InvokeStream(context.Background(), api.ModelInput{}, tt.onToken); err == nil {
+					t.Errorf("invocation %d: got no error", i)
+				}
+			}
+			if state := b.State(); state != tt.wantState {
+				t.Errorf("got state %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// ResponseCache stores responses which passed their model's filters.
+type ResponseCache interface {
+	Get(key string) (api.ModelResponse, bool)
+	Add(key string, response api.ModelResponse)
+}
+
+// CacheKey identifies the responses which may be reused for input.  Prompts differing only
+// in whitespace share a key, as do contexts differing only in line endings or leading and
+// trailing whitespace, as long as the conversation history matches.  Indentation within
+// the context is kept, it is significant in YAML.
+func CacheKey(input api.ModelInput) string {
+	key := struct {
+		Provider   string                    `json:"provider"`
+		ModelId    string                    `json:"modelId"`
+		Prompt     string                    `json:"prompt"`
+		Context    string                    `json:"context"`
+		Parameters *api.GenerationParameters `json:"parameters"`
+		Template   string                    `json:"template"`
+		Variables  map[string]string         `json:"variables"`
+		History    []api.Message             `json:"history"`
+	}{
+		Provider:   input.Provider,
+		ModelId:    input.ModelId,
+		Prompt:     normalize(input.Prompt),
+		Context:    normalizeContext(input.Context),
+		Parameters: input.Parameters,
+		Template:   input.Template,
+		Variables:  input.Variables,
+		History:    input.History,
+	}
+	buf, _ := json.Marshal(key)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalize(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func normalizeContext(s string) string {
+	return strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n"))
+}
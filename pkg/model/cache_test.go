@@ -0,0 +1,49 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+func TestCacheKey(t *testing.T) {
+	base := api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: "create a deployment", Context: "spec:\n  replicas: 3\n"}
+	tests := []struct {
+		name  string
+		input api.ModelInput
+		same  bool
+	}{
+		{
+			name:  "prompt whitespace is ignored",
+			input: api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: " create  a\ndeployment ", Context: base.Context},
+			same:  true,
+		},
+		{
+			name:  "context line endings and surrounding whitespace are ignored",
+			input: api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: base.Prompt, Context: "\r\nspec:\r\n  replicas: 3\r\n\r\n"},
+			same:  true,
+		},
+		{
+			name:  "context indentation is significant",
+			input: api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: base.Prompt, Context: "spec:\nreplicas: 3\n"},
+			same:  false,
+		},
+		{
+			name:  "model is significant",
+			input: api.ModelInput{Provider: "ollama", ModelId: "mistral", Prompt: base.Prompt, Context: base.Context},
+			same:  false,
+		},
+		{
+			name:  "template variables are significant",
+			input: api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: base.Prompt, Context: base.Context, Variables: map[string]string{"kind": "yaml"}},
+			same:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := CacheKey(tt.input) == CacheKey(base); same != tt.same {
+				t.Errorf("expected same key %t, got %t", tt.same, same)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// Coalescer shares a single invocation between concurrent callers with the same key.
+type Coalescer struct {
+	lock  sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done     chan struct{}
+	cancel   context.CancelFunc
+	waiters  int
+	response api.ModelResponse
+	err      error
+}
+
+func NewCoalescer() *Coalescer {
+	return &Coalescer{
+		calls: make(map[string]*call),
+	}
+}
+
+// CoalesceKey identifies the inputs of the authenticated user which may share an invocation.
+// Unlike CacheKey it distinguishes the credentials the model is invoked with, and the user,
+// whose quota is only checked by the invocation a request shares.  Inputs asking for a
+// fresh response with NoCache have an empty key, they are never coalesced.
+func CoalesceKey(user string, input api.ModelInput) string {
+	if input.NoCache {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(CacheKey(input) + "\x00" + user + "\x00" + input.UserId + "\x00" + input.APIKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Do calls fn, unless a call with the same key is in flight in which case it waits for that
+// call's result, reporting it as shared.  fn keeps the values of the first caller's context
+// but is only cancelled once every caller waiting for it has given up.  Calls with an empty
+// key are never shared.
+func (c *Coalescer) Do(ctx context.Context, key string, fn func(ctx context.Context) (api.ModelResponse, error)) (api.ModelResponse, bool, error) {
+	if key == "" {
+		response, err := fn(ctx)
+		return response, false, err
+	}
+	c.lock.Lock()
+	cl, shared := c.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(detachedContext{ctx})
+		cl = &call{done: make(chan struct{}), cancel: cancel}
+		c.calls[key] = cl
+		go func() {
+			cl.response, cl.err = fn(callCtx)
+			c.lock.Lock()
+			c.forget(key, cl)
+			c.lock.Unlock()
+			cancel()
+			close(cl.done)
+		}()
+	}
+	cl.waiters++
+	c.lock.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.response, shared, cl.err
+	case <-ctx.Done():
+		c.lock.Lock()
+		cl.waiters--
+		if cl.waiters == 0 {
+			// later callers must not join a cancelled call
+			c.forget(key, cl)
+			cl.cancel()
+		}
+		c.lock.Unlock()
+		return api.ModelResponse{}, shared, ctx.Err()
+	}
+}
+
+func (c *Coalescer) forget(key string, cl *call) {
+	if c.calls[key] == cl {
+		delete(c.calls, key)
+	}
+}
+
+// detachedContext carries the values of its parent without its deadline or cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (d detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (d detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (d detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
@@ -0,0 +1,210 @@
+package model
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+func TestCoalesceKey(t *testing.T) {
+	base := api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: "create a deployment", UserId: "alice", APIKey: "key"}
+	tests := []struct {
+		name  string
+		user  string
+		input api.ModelInput
+		same  bool
+	}{
+		{
+			name:  "same input",
+			user:  "alice",
+			input: base,
+			same:  true,
+		},
+		{
+			name:  "authenticated user is significant",
+			user:  "bob",
+			input: base,
+			same:  false,
+		},
+		{
+			name:  "user id is significant",
+			user:  "alice",
+			input: api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: base.Prompt, UserId: "bob", APIKey: base.APIKey},
+			same:  false,
+		},
+		{
+			name:  "api key is significant",
+			user:  "alice",
+			input: api.ModelInput{Provider: "ollama", ModelId: "llama2", Prompt: base.Prompt, UserId: base.UserId, APIKey: "other"},
+			same:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := CoalesceKey(tt.user, tt.input) == CoalesceKey("alice", base); same != tt.same {
+				t.Errorf("got same key %v, want %v", same, tt.same)
+			}
+		})
+	}
+
+	noCache := base
+	noCache.NoCache = true
+	if key := CoalesceKey("alice", noCache); key != "" {
+		t.Errorf("got key %q for a noCache input, want none", key)
+	}
+}
+
+func TestCoalescer(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		// cancel is the number of callers, from the first, which give up before the call
+		// completes
+		cancel     int
+		wantCalls  int32
+		wantShared []bool
+		wantErr    []bool
+		// wantCancelled is true if the invocation's context is cancelled
+		wantCancelled bool
+	}{
+		{
+			name:       "same key shares one call",
+			keys:       []string{"a", "a", "a"},
+			wantCalls:  1,
+			wantShared: []bool{false, true, true},
+			wantErr:    []bool{false, false, false},
+		},
+		{
+			name:       "different keys are not shared",
+			keys:       []string{"a", "b"},
+			wantCalls:  2,
+			wantShared: []bool{false, false},
+			wantErr:    []bool{false, false},
+		},
+		{
+			name:       "empty keys are never shared",
+			keys:       []string{"", ""},
+			wantCalls:  2,
+			wantShared: []bool{false, false},
+			wantErr:    []bool{false, false},
+		},
+		{
+			name:       "call continues while a caller waits",
+			keys:       []string{"a", "a"},
+			cancel:     1,
+			wantCalls:  1,
+			wantShared: []bool{false, true},
+			wantErr:    []bool{true, false},
+		},
+		{
+			name:          "call is cancelled when every caller gives up",
+			keys:          []string{"a", "a"},
+			cancel:        2,
+			wantCalls:     1,
+			wantShared:    []bool{false, true},
+			wantErr:       []bool{true, true},
+			wantCancelled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCoalescer()
+			var calls int32
+			release := make(chan struct{})
+			cancelled := make(chan bool, len(tt.keys))
+			fn := func(ctx context.Context) (api.ModelResponse, error) {
+				atomic.AddInt32(&calls, 1)
+				select {
+				case <-release:
+					cancelled <- false
+					return api.ModelResponse{Output: "ok"}, nil
+				case <-ctx.Done():
+					cancelled <- true
+					return api.ModelResponse{}, ctx.Err()
+				}
+			}
+
+			type result struct {
+				response api.ModelResponse
+				shared   bool
+				err      error
+			}
+			results := make([]result, len(tt.keys))
+			cancels := make([]context.CancelFunc, len(tt.keys))
+			done := make([]chan struct{}, len(tt.keys))
+			wg := sync.WaitGroup{}
+			for i, key := range tt.keys {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				cancels[i] = cancel
+				done[i] = make(chan struct{})
+				wg.Add(1)
+				go func(i int, key string) {
+					defer wg.Done()
+					defer close(done[i])
+					r := &results[i]
+					r.response, r.shared, r.err = c.Do(ctx, key, fn)
+				}(i, key)
+				// wait for the caller to start or join its call, so callers are in order
+				waitFor(t, func() bool {
+					if key == "" {
+						return atomic.LoadInt32(&calls) == int32(i+1)
+					}
+					c.lock.Lock()
+					defer c.lock.Unlock()
+					waiters := 0
+					for _, other := range tt.keys[:i+1] {
+						if other == key {
+							waiters++
+						}
+					}
+					return c.calls[key] != nil && c.calls[key].waiters == waiters
+				})
+			}
+
+			for i := 0; i < tt.cancel; i++ {
+				cancels[i]()
+				<-done[i]
+			}
+			if !tt.wantCancelled {
+				close(release)
+			}
+			wg.Wait()
+
+			if calls != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+			for i, r := range results {
+				if r.shared != tt.wantShared[i] {
+					t.Errorf("caller %d: got shared %v, want %v", i, r.shared, tt.wantShared[i])
+				}
+				if (r.err != nil) != tt.wantErr[i] {
+					t.Errorf("caller %d: got error %v, want error %v", i, r.err, tt.wantErr[i])
+				}
+				if r.err == nil && r.response.Output != "ok" {
+					t.Errorf("caller %d: got output %q, want ok", i, r.response.Output)
+				}
+			}
+			if tt.wantCalls == 1 {
+				if got := <-cancelled; got != tt.wantCancelled {
+					t.Errorf("got call cancelled %v, want %v", got, tt.wantCancelled)
+				}
+			}
+		})
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"context"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// Filtered is a model with additional input filters applied ahead of the model's own.
+type Filtered struct {
+	api.Model
+	inputFilters []api.InputFilter
+}
+
+func NewFiltered(m api.Model, inputFilters ...api.InputFilter) *Filtered {
+	return &Filtered{Model: m, inputFilters: inputFilters}
+}
+
+func (f *Filtered) GetFilter() api.Filter {
+	filter := f.Model.GetFilter()
+	chain := append([]api.InputFilter{}, f.inputFilters...)
+	filter.InputFilterChain = append(chain, filter.InputFilterChain...)
+	return filter
+}
+
+func (f *Filtered) InvokeStream(ctx context.Context, input api.ModelInput, onToken func(string) error) (api.ModelResponse, error) {
+	if sm, ok := f.Model.(api.StreamingModel); ok {
+		return sm.InvokeStream(ctx, input, onToken)
+	}
+	response, err := f.Model.Invoke(ctx, input)
+	if err == nil {
+		err = onToken(response.Output)
+	}
+	return response, err
+}
@@ -1,30 +1,153 @@
 package ibm
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-
-	huggingface "github.com/hupe1980/go-huggingface"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/model"
+)
+
+const (
+	inferenceEndpoint = "https://api-inference.huggingface.co/models/"
+
+	// apiInference is the hosted Inference API and Inference Endpoints request format.
+	apiInference = "inference"
+	// apiTGI is the text-generation-inference server /generate and /generate_stream format.
+	apiTGI = "tgi"
+
+	defaultMaxNewTokens       = 100
+	defaultMaxTime            = 30.0
+	defaultNumReturnSequences = 2
+	// defaultTGINumReturnSequences is lower as more than one sequence requires sampling with
+	// best_of, which TGI must be configured to allow.
+	defaultTGINumReturnSequences = 1
 )
 
+// HFOptions are the provider specific options for the huggingface provider.
+type HFOptions struct {
+	// API selects the request format used with the configured url, "inference" (the default)
+	// or "tgi".  When no url is configured the hosted Inference API is used.
+	API string `yaml:"api"`
+}
+
+func init() {
+	model.RegisterProvider("huggingface", func(config api.ModelConfig) (api.Model, error) {
+		options := HFOptions{}
+		if err := config.DecodeOptions(&options); err != nil {
+			return nil, err
+		}
+		switch options.API {
+		case "":
+			options.API = apiInference
+		case apiInference, apiTGI:
+		default:
+			return nil, fmt.Errorf("invalid api %q, must be %q or %q", options.API, apiInference, apiTGI)
+		}
+		if options.API == apiTGI && config.URL == "" {
+			return nil, fmt.Errorf("url is required for the %s api", apiTGI)
+		}
+		if _, err := config.Parameters.ExtraInt("numReturnSequences", defaultNumReturnSequences); err != nil {
+			return nil, err
+		}
+		m := NewHFModel(config.ModelId, config.URL, config.APIKey, options.API, config.Timeout, config.Parameters, config.ParameterLimits)
+		m.client = model.NewHTTPClient(config.Retry)
+		prompts, err := model.NewPromptBuilder(config.Context)
+		if err != nil {
+			return nil, err
+		}
+		m.prompts = prompts
+		return m, nil
+	})
+}
+
+type HFModelParameters struct {
+	MaxNewTokens *int     `json:"max_new_tokens,omitempty"`
+	Temperature  *float64 `json:"temperature,omitempty"`
+	TopP         *float64 `json:"top_p,omitempty"`
+	Stop         []string `json:"stop,omitempty"`
+
+	// Inference API only
+	MaxTime            *float64 `json:"max_time,omitempty"`
+	NumReturnSequences *int     `json:"num_return_sequences,omitempty"`
+	ReturnFullText     *bool    `json:"return_full_text,omitempty"`
+
+	// TGI only
+	BestOf   *int  `json:"best_of,omitempty"`
+	DoSample *bool `json:"do_sample,omitempty"`
+	Details  bool  `json:"details,omitempty"`
+}
+
+type HFModelRequestPayload struct {
+	Inputs     string            `json:"inputs"`
+	Parameters HFModelParameters `json:"parameters"`
+	Stream     bool              `json:"stream,omitempty"`
+}
+
+// HFGeneration is a single generated sequence, returned as a list by the Inference API and
+// as a single object, with any additional best_of sequences in its details, by TGI.
+type HFGeneration struct {
+	GeneratedText string `json:"generated_text"`
+	Details       *struct {
+		GeneratedTokens int `json:"generated_tokens"`
+		BestOfSequences []struct {
+			GeneratedTokens int    `json:"generated_tokens"`
+			GeneratedText   string `json:"generated_text"`
+		} `json:"best_of_sequences"`
+	} `json:"details"`
+}
+
+// HFStreamResponsePayload is a single event of a streamed text generation.
+type HFStreamResponsePayload struct {
+	Token struct {
+		Text    string `json:"text"`
+		Special bool   `json:"special"`
+	} `json:"token"`
+	GeneratedText *string `json:"generated_text"`
+	Error         string  `json:"error"`
+}
+
+type HFErrorPayload struct {
+	Error string `json:"error"`
+}
+
 type HFModel struct {
-	modelId string
-	url     string
-	apiKey  string
-	filter  api.Filter
+	modelId    string
+	url        string
+	apiKey     string
+	api        string
+	timeout    time.Duration
+	parameters api.GenerationParameters
+	limits     api.GenerationLimits
+	client     *http.Client
+	prompts    *model.PromptBuilder
+	filter     api.Filter
 }
 
-func NewHFModel(modelId, url, apiKey string) *HFModel {
+func NewHFModel(modelId, url, apiKey, apiName string, timeout time.Duration, parameters api.GenerationParameters, limits api.GenerationLimits) *HFModel {
 	//filter := api.NewFilter(nil, []api.ResponseFilter{markdown.MarkdownStripper, yaml.YamlLinter})
 	filter := api.Filter{}
 
+	if url == "" {
+		url = inferenceEndpoint + modelId
+	}
+
 	return &HFModel{
-		modelId: modelId,
-		url:     url,
-		apiKey:  apiKey,
-		filter:  filter,
+		modelId:    modelId,
+		url:        strings.TrimSuffix(url, "/"),
+		apiKey:     apiKey,
+		api:        apiName,
+		timeout:    timeout,
+		parameters: parameters,
+		limits:     limits,
+		client:     &http.Client{},
+		prompts:    model.DefaultPromptBuilder(),
+		filter:     filter,
 	}
 }
 
@@ -32,39 +155,209 @@ func (m *HFModel) GetFilter() api.Filter {
 	return m.filter
 }
 
-func (m *HFModel) Invoke(input api.ModelInput) (api.ModelResponse, error) {
+func (m *HFModel) Invoke(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
+	params := api.ResolveParameters(m.parameters, m.limits, input.Parameters)
+	payload := m.newRequest(input, params, false)
+	defaultN := defaultNumReturnSequences
+	if m.api == apiTGI {
+		defaultN = defaultTGINumReturnSequences
+	}
+	n, err := params.ExtraInt("numReturnSequences", defaultN)
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+	apiURL := m.url
+	if m.api == apiTGI {
+		apiURL += "/generate"
+		if n > 1 {
+			sample := true
+			payload.Parameters.BestOf = &n
+			payload.Parameters.DoSample = &sample
+		}
+		payload.Parameters.Details = true
+	} else {
+		payload.Parameters.NumReturnSequences = &n
+	}
+
+	ctx, cancel := m.withTimeout(ctx, params)
+	defer cancel()
 
-	if input.APIKey == "" && m.apiKey == "" {
-		return api.ModelResponse{}, fmt.Errorf("api key is required, none provided")
+	resp, err := m.doRequest(ctx, input, apiURL, payload)
+	if err != nil {
+		return api.ModelResponse{}, err
 	}
+	defer resp.Body.Close()
 
-	apiKey := m.apiKey
-	if input.APIKey != "" {
-		apiKey = input.APIKey
+	var generations []HFGeneration
+	if m.api == apiTGI {
+		generation := HFGeneration{}
+		err = json.NewDecoder(resp.Body).Decode(&generation)
+		generations = append(generations, generation)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&generations)
+	}
+	if err != nil {
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error decoding api response")
+	}
+
+	candidates := []string{}
+	generatedTokens := 0
+	for _, generation := range generations {
+		candidates = append(candidates, generation.GeneratedText)
+		if generation.Details != nil {
+			generatedTokens += generation.Details.GeneratedTokens
+			for _, sequence := range generation.Details.BestOfSequences {
+				candidates = append(candidates, sequence.GeneratedText)
+				generatedTokens += sequence.GeneratedTokens
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, nil, "model returned no valid responses")
+	}
+
+	response := api.ModelResponse{}
+	response.Input = input.Prompt
+	response.Output = candidates[0]
+	response.RawOutput = candidates[0]
+	response.Candidates = candidates
+	if generatedTokens > 0 {
+		// TGI reports the generated tokens, but not the prompt tokens
+		response.Usage = api.Usage{
+			PromptTokens:     api.EstimateTokens(payload.Inputs),
+			CompletionTokens: generatedTokens,
+			Estimated:        true,
+		}
+		response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
+	} else {
+		response.Usage = api.EstimateUsage(payload.Inputs, response.Output)
 	}
-	client := huggingface.NewInferenceClient(apiKey)
-	client.SetModel(m.modelId)
 
-	req := &huggingface.TextGenerationRequest{
-		Inputs: input.Prompt,
-		Model:  input.ModelId,
+	return response, nil
+}
+
+func (m *HFModel) InvokeStream(ctx context.Context, input api.ModelInput, onToken func(string) error) (api.ModelResponse, error) {
+	params := api.ResolveParameters(m.parameters, m.limits, input.Parameters)
+	payload := m.newRequest(input, params, true)
+	apiURL := m.url
+	if m.api == apiTGI {
+		apiURL += "/generate_stream"
 	}
 
-	a := 100
-	req.Parameters.MaxNewTokens = &a
-	b := 30.0
-	req.Parameters.MaxTime = &b
-	c := 2
-	req.Parameters.NumReturnSequences = &c
+	ctx, cancel := m.withTimeout(ctx, params)
+	defer cancel()
 
-	resp, err := client.TextGeneration(context.Background(), req)
+	resp, err := m.doRequest(ctx, input, apiURL, payload)
 	if err != nil {
-		return api.ModelResponse{}, fmt.Errorf("error making api request: %v", err)
+		return api.ModelResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	output := strings.Builder{}
+	err = model.ReadSSE(resp.Body, func(data []byte) error {
+		var event HFStreamResponsePayload
+		if err := json.Unmarshal(data, &event); err != nil {
+			return api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error decoding api response event")
+		}
+		if event.Error != "" {
+			return api.NewError(api.ErrorCodeUpstreamUnavailable, nil, "huggingface error: %s", event.Error)
+		}
+		if event.Token.Special || event.Token.Text == "" {
+			return nil
+		}
+		output.WriteString(event.Token.Text)
+		return onToken(event.Token.Text)
+	})
+	if err != nil {
+		return api.ModelResponse{}, err
 	}
 
 	response := api.ModelResponse{}
 	response.Input = input.Prompt
-	response.Output = resp.GeneratedText
+	response.Output = output.String()
+	response.RawOutput = output.String()
+	response.Usage = api.EstimateUsage(payload.Inputs, response.Output)
+
+	return response, nil
+}
+
+func (m *HFModel) newRequest(input api.ModelInput, params api.GenerationParameters, stream bool) HFModelRequestPayload {
+	payload := HFModelRequestPayload{
+		Inputs: m.prompts.Prompt(input),
+		Stream: stream,
+	}
+
+	payload.Parameters.MaxNewTokens = params.MaxTokens
+	if payload.Parameters.MaxNewTokens == nil {
+		a := defaultMaxNewTokens
+		payload.Parameters.MaxNewTokens = &a
+	}
+	payload.Parameters.Temperature = params.Temperature
+	payload.Parameters.TopP = params.TopP
+	payload.Parameters.Stop = params.Stop
+
+	if m.api == apiInference {
+		payload.Parameters.MaxTime = params.TimeLimit
+		if payload.Parameters.MaxTime == nil {
+			b := defaultMaxTime
+			payload.Parameters.MaxTime = &b
+		}
+		fullText := false
+		payload.Parameters.ReturnFullText = &fullText
+	}
+	return payload
+}
+
+// withTimeout applies the generation time limit client side for TGI, which unlike the
+// Inference API does not accept a max_time parameter.
+func (m *HFModel) withTimeout(ctx context.Context, params api.GenerationParameters) (context.Context, context.CancelFunc) {
+	if m.api == apiTGI {
+		return model.WithTimeout(ctx, m.timeout, params.TimeLimit)
+	}
+	return model.WithTimeout(ctx, m.timeout, nil)
+}
+
+// doRequest sends the generation request and returns the response if the api call succeeded.
+func (m *HFModel) doRequest(ctx context.Context, input api.ModelInput, apiURL string, payload HFModelRequestPayload) (*http.Response, error) {
+	apiKey := m.apiKey
+	if input.APIKey != "" {
+		apiKey = input.APIKey
+	}
+	if apiKey == "" && m.url == inferenceEndpoint+m.modelId {
+		return nil, api.NewError(api.ErrorCodeAuthFailed, nil, "api key is required, none provided")
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
 
-	return response, err
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if payload.Stream {
+		req.Header.Set("Accept", "text/event-stream")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error making api request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr HFErrorPayload
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return nil, api.ErrorFromStatus(resp.StatusCode, "API request failed with status: %s: %s", resp.Status, apiErr.Error)
+		}
+		return nil, api.ErrorFromStatus(resp.StatusCode, "API request failed with status: %s", resp.Status)
+	}
+	return resp, nil
 }
@@ -2,20 +2,59 @@ package ibm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/openshift/wisdom/pkg/api"
 	"github.com/openshift/wisdom/pkg/filters/markdown"
 	"github.com/openshift/wisdom/pkg/filters/yaml"
+	"github.com/openshift/wisdom/pkg/model"
+)
+
+func init() {
+	model.RegisterProvider("ibm", func(config api.ModelConfig) (api.Model, error) {
+		if config.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		if err := config.DecodeOptions(nil); err != nil {
+			return nil, err
+		}
+		m := NewIBMModel(config.ModelId, config.URL, config.UserId, config.APIKey, config.Timeout, config.Parameters, config.ParameterLimits)
+		m.client = model.NewHTTPClient(config.Retry)
+		prompts, err := model.NewPromptBuilder(config.Context)
+		if err != nil {
+			return nil, err
+		}
+		m.prompts = prompts
+		return m, nil
+	})
+}
+
+const (
+	defaultTaskID = "yaml-only-raw-output"
+	defaultMode   = "synchronous"
 )
 
 type IBMModelRequestPayload struct {
-	Prompt  string `json:"prompt"`
-	ModelID string `json:"model_id"`
-	TaskID  string `json:"task_id"`
-	Mode    string `json:"mode"`
+	Prompt     string              `json:"prompt"`
+	ModelID    string              `json:"model_id"`
+	TaskID     string              `json:"task_id"`
+	Mode       string              `json:"mode"`
+	Parameters *IBMModelParameters `json:"parameters,omitempty"`
+}
+
+type IBMModelParameters struct {
+	MaxNewTokens  *int     `json:"max_new_tokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// TimeLimit is in milliseconds
+	TimeLimit *int `json:"time_limit,omitempty"`
 }
 
 type IBMModelResponsePayload struct {
@@ -29,21 +68,31 @@ type IBMModelResponsePayload struct {
 }
 
 type IBMModel struct {
-	modelId string
-	url     string
-	apiKey  string
-	userId  string
-	filter  api.Filter
+	modelId    string
+	url        string
+	apiKey     string
+	userId     string
+	timeout    time.Duration
+	parameters api.GenerationParameters
+	limits     api.GenerationLimits
+	client     *http.Client
+	prompts    *model.PromptBuilder
+	filter     api.Filter
 }
 
-func NewIBMModel(modelId, url, userId, apiKey string) *IBMModel {
+func NewIBMModel(modelId, url, userId, apiKey string, timeout time.Duration, parameters api.GenerationParameters, limits api.GenerationLimits) *IBMModel {
 	filter := api.NewFilter(nil, []api.ResponseFilter{markdown.MarkdownStripper, yaml.YamlLinter})
 	return &IBMModel{
-		modelId: modelId,
-		url:     url,
-		apiKey:  apiKey,
-		userId:  userId,
-		filter:  filter,
+		modelId:    modelId,
+		url:        url,
+		apiKey:     apiKey,
+		userId:     userId,
+		timeout:    timeout,
+		parameters: parameters,
+		limits:     limits,
+		client:     &http.Client{},
+		prompts:    model.DefaultPromptBuilder(),
+		filter:     filter,
 	}
 }
 
@@ -51,13 +100,13 @@ func (m *IBMModel) GetFilter() api.Filter {
 	return m.filter
 }
 
-func (m *IBMModel) Invoke(input api.ModelInput) (api.ModelResponse, error) {
+func (m *IBMModel) Invoke(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
 
 	if input.UserId == "" && m.userId == "" {
-		return api.ModelResponse{}, fmt.Errorf("user email address is required, none provided")
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeAuthFailed, nil, "user email address is required, none provided")
 	}
 	if input.APIKey == "" && m.apiKey == "" {
-		return api.ModelResponse{}, fmt.Errorf("api key is required, none provided")
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeAuthFailed, nil, "api key is required, none provided")
 	}
 
 	apiKey, userId := m.apiKey, m.userId
@@ -68,11 +117,24 @@ func (m *IBMModel) Invoke(input api.ModelInput) (api.ModelResponse, error) {
 		userId = input.UserId
 	}
 
+	params := api.ResolveParameters(m.parameters, m.limits, input.Parameters)
 	payload := IBMModelRequestPayload{
-		Prompt:  input.Prompt,
+		Prompt:  m.prompts.Prompt(input),
 		ModelID: m.modelId,
-		TaskID:  "yaml-only-raw-output",
-		Mode:    "synchronous",
+		TaskID:  params.ExtraString("taskId", defaultTaskID),
+		Mode:    params.ExtraString("mode", defaultMode),
+	}
+	if params.MaxTokens != nil || params.Temperature != nil || params.TopP != nil || params.Stop != nil || params.TimeLimit != nil {
+		payload.Parameters = &IBMModelParameters{
+			MaxNewTokens:  params.MaxTokens,
+			Temperature:   params.Temperature,
+			TopP:          params.TopP,
+			StopSequences: params.Stop,
+		}
+		if params.TimeLimit != nil {
+			ms := int(*params.TimeLimit * 1000)
+			payload.Parameters.TimeLimit = &ms
+		}
 	}
 
 	// Convert the payload to JSON
@@ -82,8 +144,11 @@ func (m *IBMModel) Invoke(input api.ModelInput) (api.ModelResponse, error) {
 		return api.ModelResponse{}, err
 	}
 
+	ctx, cancel := model.WithTimeout(ctx, m.timeout, nil)
+	defer cancel()
+
 	apiURL := m.url + "/api/v1/jobs"
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonPayload))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		//fmt.Println("Error creating HTTP request:", err)
 		return api.ModelResponse{}, err
@@ -97,36 +162,44 @@ func (m *IBMModel) Invoke(input api.ModelInput) (api.ModelResponse, error) {
 	req.Header.Set("Email", userId)
 
 	// Make the API call
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := m.client.Do(req)
 	if err != nil {
-		//fmt.Println("Error making API request:", err)
-		return api.ModelResponse{}, err
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error making api request")
 	}
 	defer resp.Body.Close()
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return api.ModelResponse{}, fmt.Errorf("API request failed with status: %v", resp.Status)
+		return api.ModelResponse{}, api.ErrorFromStatus(resp.StatusCode, "API request failed with status: %v", resp.Status)
 	}
 
 	// Parse the JSON response into the APIResponse struct
 	var apiResp IBMModelResponsePayload
 	err = json.NewDecoder(resp.Body).Decode(&apiResp)
 	if err != nil {
-		//fmt.Println("Error decoding API response:", err)
-		return api.ModelResponse{}, err
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error decoding api response")
 	}
 	response := api.ModelResponse{}
 	response.Input = input.Prompt
 	response.Output = apiResp.TaskOutput
 	response.RawOutput = apiResp.AllTokens
 	//output := apiResp.AllTokens[len(apiResp.InputTokens):]
-	response.RequestID = apiResp.JobID
+	log.Debugf("ibm job id: %s", apiResp.JobID)
+	// the api returns the tokenized text rather than token counts
+	if apiResp.AllTokens != "" {
+		response.Usage = api.Usage{
+			PromptTokens: api.EstimateTokens(apiResp.InputTokens),
+			TotalTokens:  api.EstimateTokens(apiResp.AllTokens),
+			Estimated:    true,
+		}
+		response.Usage.CompletionTokens = response.Usage.TotalTokens - response.Usage.PromptTokens
+	} else {
+		response.Usage = m.prompts.EstimateUsage(input, response.Output)
+	}
 
 	return response, err
 }
 
-func (m *IBMModel) FilterInput(input api.ModelInput) (api.ModelInput, error) {
-	return m.filter.FilterInput(input)
+func (m *IBMModel) FilterInput(ctx context.Context, input api.ModelInput) (api.ModelInput, error) {
+	return m.filter.FilterInput(ctx, input)
 }
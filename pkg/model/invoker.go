@@ -1,31 +1,171 @@
 package model
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/metrics"
+	"github.com/openshift/wisdom/pkg/tracing"
 )
 
-func InvokeModel(input api.ModelInput, model api.Model) (api.ModelResponse, error) {
+type invokeFunc func(ctx context.Context, input api.ModelInput) (api.ModelResponse, error)
+
+// AdmitFunc is called with the filtered input before the named model is invoked.  It returns
+// an error if the model must not be invoked, such as when the user's quota is exhausted.
+type AdmitFunc func(model string, input api.ModelInput) error
+
+func InvokeModel(ctx context.Context, input api.ModelInput, model api.Model) (api.ModelResponse, error) {
+	return invoke(ctx, input, model, nil, model.Invoke)
+}
+
+// InvokeModelStream invokes the model, passing output tokens to onToken as they are generated.
+// Models which do not support streaming deliver their entire output as a single token.  The
+// response filters are applied to the assembled output once the model has finished.  admit
+// may be nil.
+func InvokeModelStream(ctx context.Context, input api.ModelInput, model api.Model, admit AdmitFunc, onToken func(string) error) (api.ModelResponse, error) {
+	if sm, ok := model.(api.StreamingModel); ok {
+		return invoke(ctx, input, model, admit, func(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
+			return sm.InvokeStream(ctx, input, onToken)
+		})
+	}
+	return invoke(ctx, input, model, admit, func(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
+		response, err := model.Invoke(ctx, input)
+		if err != nil {
+			return response, err
+		}
+		return response, onToken(response.Output)
+	})
+}
+
+func invoke(ctx context.Context, input api.ModelInput, model api.Model, admit AdmitFunc, invoker invokeFunc) (api.ModelResponse, error) {
+	name := input.Provider + "/" + input.ModelId
+	ctx, span := tracing.Start(ctx, "invoke "+name, trace.SpanKindInternal)
+	defer span.End()
+	span.SetAttributes(attribute.String("model", name))
+
 	log.Debugf("model input:\n%#v", input)
-	input, err := model.GetFilter().FilterInput(input)
+	input, err := model.GetFilter().FilterInput(ctx, input)
 	if err != nil {
-		return api.ModelResponse{}, fmt.Errorf("error filtering input: %s", err)
+		observeFilterRejection(name, err)
+		tracing.SetError(span, err)
+		return api.ModelResponse{}, err
 	}
 	log.Debugf("model filtered input:\n%#v", input)
-	response, err := model.Invoke(input)
+	if admit != nil {
+		if err := admit(name, input); err != nil {
+			tracing.SetError(span, err)
+			return api.ModelResponse{}, err
+		}
+	}
+	start := time.Now()
+	response, err := invoker(ctx, input)
+	metrics.UpstreamDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
 	log.Debugf("model response:\n%#v\nerror: %v", response, err)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, api.ErrTimeout) {
+			err = api.NewError(api.ErrorCodeTimeout, err, "model invocation timed out")
+		}
+		metrics.UpstreamRequests.WithLabelValues(name, string(api.ErrorCodeOf(err))).Inc()
+		tracing.SetError(span, err)
 		response.Error = err.Error()
 		return response, err
 	}
+	metrics.UpstreamRequests.WithLabelValues(name, "ok").Inc()
+	response.Sources = input.Sources
+	if response.Usage.TotalTokens == 0 {
+		// the providers estimate usage from the prompt they built, this only covers those
+		// which do not
+		response.Usage = api.Usage{
+			PromptTokens:     api.EstimateInputTokens(input),
+			CompletionTokens: api.EstimateTokens(response.Output),
+			Estimated:        true,
+		}
+		response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
+	}
+	metrics.Tokens.WithLabelValues(name, "prompt").Add(float64(response.Usage.PromptTokens))
+	metrics.Tokens.WithLabelValues(name, "completion").Add(float64(response.Usage.CompletionTokens))
+	span.SetAttributes(
+		attribute.Int("usage.prompt_tokens", response.Usage.PromptTokens),
+		attribute.Int("usage.completion_tokens", response.Usage.CompletionTokens),
+	)
 
-	output, err := model.GetFilter().FilterResponse(response)
+	output, err := model.GetFilter().FilterResponse(ctx, response)
 	if err != nil {
-		response.Error = err.Error()
+		observeFilterRejection(name, err)
+		tracing.SetError(span, err)
+		output.Error = err.Error()
 	}
 	log.Debugf("model filtered output:\n%#v", output)
 	return output, err
 }
+
+func observeFilterRejection(name string, err error) {
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) && apiErr.Filter != "" {
+		metrics.FilterRejections.WithLabelValues(name, apiErr.Filter).Inc()
+	}
+}
+
+// InvokeModelChain invokes each of the named models in turn until one produces a response
+// which passes its filters.  The response records which model produced it and why any
+// earlier models failed.  If every model fails the last model's response and error are returned.
+// When cache is not nil responses are reused from, and added to, it unless the input opts out.
+// Models refused by admit, which may be nil, are skipped.
+func InvokeModelChain(ctx context.Context, input api.ModelInput, models map[string]api.Model, chain []string, cache ResponseCache, admit AdmitFunc) (api.ModelResponse, error) {
+	var response api.ModelResponse
+	var failures []api.ModelFailure
+	var err error = api.NewError(api.ErrorCodeBadRequest, nil, "no models to invoke")
+	for _, name := range chain {
+		m, found := models[name]
+		if !found {
+			return response, api.NewError(api.ErrorCodeBadRequest, nil, "model %s not found", name)
+		}
+		provider, modelId, _ := strings.Cut(name, "/")
+		input.Provider, input.ModelId = provider, modelId
+
+		useCache := cache != nil && !input.NoCache
+		key := ""
+		if useCache {
+			key = CacheKey(input)
+			cached, found := cache.Get(key)
+			if !found {
+				metrics.CacheRequests.WithLabelValues(name, "miss").Inc()
+			} else {
+				metrics.CacheRequests.WithLabelValues(name, "hit").Inc()
+				log.Debugf("using cached response from model %s", name)
+				cached.Cached = true
+				cached.Model = name
+				cached.FailedModels = failures
+				return cached, nil
+			}
+		}
+
+		response, err = invoke(ctx, input, m, admit, m.Invoke)
+		if err == nil && useCache {
+			cache.Add(key, response)
+		}
+		response.Model = name
+		response.FailedModels = failures
+		if err == nil {
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			// the caller has given up, there is no point trying other models
+			return response, err
+		}
+		if errors.Is(err, api.ErrBadRequest) {
+			// other models would reject the same request
+			return response, err
+		}
+		log.Infof("model %s failed, %d fallback models remaining: %v", name, len(chain)-len(failures)-1, err)
+		failures = append(failures, api.ModelFailure{Model: name, Code: api.ErrorCodeOf(err), Error: err.Error(), Usage: response.Usage})
+	}
+	return response, err
+}
@@ -0,0 +1,240 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/model"
+)
+
+const (
+	defaultURL = "http://localhost:11434"
+
+	apiGenerate = "generate"
+	apiChat     = "chat"
+)
+
+// OllamaOptions are the provider specific options for the ollama provider.
+type OllamaOptions struct {
+	// API selects the ollama endpoint used for inference, "generate" (the default) or "chat".
+	API string `yaml:"api"`
+}
+
+func init() {
+	model.RegisterProvider("ollama", func(config api.ModelConfig) (api.Model, error) {
+		options := OllamaOptions{}
+		if err := config.DecodeOptions(&options); err != nil {
+			return nil, err
+		}
+		switch options.API {
+		case "":
+			options.API = apiGenerate
+		case apiGenerate, apiChat:
+		default:
+			return nil, fmt.Errorf("invalid api %q, must be %q or %q", options.API, apiGenerate, apiChat)
+		}
+		url := config.URL
+		if url == "" {
+			url = defaultURL
+		}
+		m := NewOllamaModel(config.ModelId, url, options.API, config.Timeout, config.Parameters, config.ParameterLimits)
+		m.client = model.NewHTTPClient(config.Retry)
+		prompts, err := model.NewPromptBuilder(config.Context)
+		if err != nil {
+			return nil, err
+		}
+		m.prompts = prompts
+		return m, nil
+	})
+}
+
+type OllamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type OllamaModelRequestPayload struct {
+	Model    string              `json:"model"`
+	Prompt   string              `json:"prompt,omitempty"`
+	Messages []OllamaMessage     `json:"messages,omitempty"`
+	Stream   bool                `json:"stream"`
+	Options  *OllamaModelOptions `json:"options,omitempty"`
+}
+
+type OllamaModelOptions struct {
+	NumPredict  *int     `json:"num_predict,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// OllamaModelResponsePayload is a complete response, or a single line of a streamed
+// response, from either the generate or the chat endpoint.
+type OllamaModelResponsePayload struct {
+	Model    string         `json:"model"`
+	Response string         `json:"response"`
+	Message  *OllamaMessage `json:"message"`
+	Done     bool           `json:"done"`
+	Error    string         `json:"error"`
+
+	// Set on the final response
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+func (p OllamaModelResponsePayload) content() string {
+	if p.Message != nil {
+		return p.Message.Content
+	}
+	return p.Response
+}
+
+type OllamaModel struct {
+	modelId    string
+	url        string
+	api        string
+	timeout    time.Duration
+	parameters api.GenerationParameters
+	limits     api.GenerationLimits
+	client     *http.Client
+	prompts    *model.PromptBuilder
+	filter     api.Filter
+}
+
+func NewOllamaModel(modelId, url, apiName string, timeout time.Duration, parameters api.GenerationParameters, limits api.GenerationLimits) *OllamaModel {
+	filter := api.NewFilter(nil, nil)
+
+	return &OllamaModel{
+		modelId:    modelId,
+		url:        strings.TrimSuffix(url, "/"),
+		api:        apiName,
+		timeout:    timeout,
+		parameters: parameters,
+		limits:     limits,
+		client:     &http.Client{},
+		prompts:    model.DefaultPromptBuilder(),
+		filter:     filter,
+	}
+}
+
+func (m *OllamaModel) GetFilter() api.Filter {
+	return m.filter
+}
+
+func (m *OllamaModel) Invoke(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
+	return m.invoke(ctx, input, false, nil)
+}
+
+func (m *OllamaModel) InvokeStream(ctx context.Context, input api.ModelInput, onToken func(string) error) (api.ModelResponse, error) {
+	return m.invoke(ctx, input, true, onToken)
+}
+
+func (m *OllamaModel) invoke(ctx context.Context, input api.ModelInput, stream bool, onToken func(string) error) (api.ModelResponse, error) {
+	params := api.ResolveParameters(m.parameters, m.limits, input.Parameters)
+	payload := OllamaModelRequestPayload{
+		Model:  m.modelId,
+		Stream: stream,
+	}
+	if params.MaxTokens != nil || params.Temperature != nil || params.TopP != nil || params.Stop != nil {
+		payload.Options = &OllamaModelOptions{
+			NumPredict:  params.MaxTokens,
+			Temperature: params.Temperature,
+			TopP:        params.TopP,
+			Stop:        params.Stop,
+		}
+	}
+	if m.api == apiChat {
+		if message, ok := m.prompts.ContextMessage(input); ok {
+			payload.Messages = append(payload.Messages, OllamaMessage{Role: message.Role, Content: message.Content})
+		}
+		for _, message := range input.History {
+			payload.Messages = append(payload.Messages, OllamaMessage{Role: message.Role, Content: message.Content})
+		}
+		payload.Messages = append(payload.Messages, OllamaMessage{Role: "user", Content: input.Prompt})
+	} else {
+		payload.Prompt = m.prompts.Prompt(input)
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+
+	ctx, cancel := model.WithTimeout(ctx, m.timeout, params.TimeLimit)
+	defer cancel()
+
+	apiURL := m.url + "/api/" + m.api
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error making api request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiResp OllamaModelResponsePayload
+		if json.NewDecoder(resp.Body).Decode(&apiResp) == nil && apiResp.Error != "" {
+			return api.ModelResponse{}, api.ErrorFromStatus(resp.StatusCode, "API request failed with status: %s: %s", resp.Status, apiResp.Error)
+		}
+		return api.ModelResponse{}, api.ErrorFromStatus(resp.StatusCode, "API request failed with status: %s", resp.Status)
+	}
+
+	// Streamed responses are newline delimited JSON objects, the last of which has done set.
+	// Non-streamed responses are a single object in the same format.
+	output := strings.Builder{}
+	usage := api.Usage{}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var apiResp OllamaModelResponsePayload
+		err := decoder.Decode(&apiResp)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error decoding api response")
+		}
+		if apiResp.Error != "" {
+			return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, nil, "ollama error: %s", apiResp.Error)
+		}
+		if token := apiResp.content(); token != "" {
+			output.WriteString(token)
+			if onToken != nil {
+				if err := onToken(token); err != nil {
+					return api.ModelResponse{}, err
+				}
+			}
+		}
+		if apiResp.Done {
+			usage.PromptTokens = apiResp.PromptEvalCount
+			usage.CompletionTokens = apiResp.EvalCount
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			break
+		}
+	}
+	if output.Len() == 0 {
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, nil, "model returned no valid responses")
+	}
+
+	response := api.ModelResponse{}
+	response.Input = input.Prompt
+	response.Output = output.String()
+	response.RawOutput = output.String()
+	response.Usage = usage
+	if response.Usage.TotalTokens == 0 {
+		response.Usage = m.prompts.EstimateUsage(input, response.Output)
+	}
+	return response, nil
+}
@@ -2,13 +2,72 @@ package openai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/model"
 )
 
+const defaultPathPrefix = "/v1"
+
+// OpenAIOptions are the provider specific options for the openai and openai-compatible providers.
+type OpenAIOptions struct {
+	// SystemPrompt is sent as a system message ahead of the user's prompt.
+	SystemPrompt string `yaml:"systemPrompt"`
+
+	// PathPrefix is prepended to /chat/completions, defaults to /v1.  Azure style deployments
+	// use /openai/deployments/<deployment name>.
+	PathPrefix string `yaml:"pathPrefix"`
+	// APIVersion is sent as the api-version query parameter when set.
+	APIVersion string `yaml:"apiVersion"`
+	// APIKeyHeader names the header used to send the api key.  By default the key is
+	// sent as a bearer token in the Authorization header.
+	APIKeyHeader string `yaml:"apiKeyHeader"`
+	// Headers are additional headers sent with every request.
+	Headers map[string]string `yaml:"headers"`
+	// StreamUsage requests token usage in streamed responses with stream_options, which not
+	// all compatible servers accept.
+	StreamUsage bool `yaml:"streamUsage"`
+}
+
+func init() {
+	model.RegisterProvider("openai", newFactory(true))
+	// openai-compatible covers self hosted servers such as vLLM, llama.cpp and LocalAI
+	// which typically do not require an api key.
+	model.RegisterProvider("openai-compatible", newFactory(false))
+}
+
+func newFactory(requireAPIKey bool) model.ModelFactory {
+	return func(config api.ModelConfig) (api.Model, error) {
+		if config.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		options := OpenAIOptions{}
+		if err := config.DecodeOptions(&options); err != nil {
+			return nil, err
+		}
+		if options.PathPrefix == "" {
+			options.PathPrefix = defaultPathPrefix
+		}
+		m := NewOpenAIModel(config.ModelId, config.URL, config.APIKey, config.Timeout, config.Parameters, config.ParameterLimits, options)
+		m.requireAPIKey = requireAPIKey
+		m.client = model.NewHTTPClient(config.Retry)
+		prompts, err := model.NewPromptBuilder(config.Context)
+		if err != nil {
+			return nil, err
+		}
+		m.prompts = prompts
+		return m, nil
+	}
+}
+
 // OpenAI
 type OpenAIMessage struct {
 	Role    string `json:"role"`
@@ -16,8 +75,24 @@ type OpenAIMessage struct {
 }
 
 type OpenAIModelRequestPayload struct {
-	Model    string          `json:"model"`
-	Messages []OpenAIMessage `json:"messages"`
+	Model         string               `json:"model"`
+	Messages      []OpenAIMessage      `json:"messages"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	MaxTokens     *int                 `json:"max_tokens,omitempty"`
+	Stop          []string             `json:"stop,omitempty"`
+}
+
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type OpenAIModelResponsePayload struct {
@@ -26,23 +101,49 @@ type OpenAIModelResponsePayload struct {
 		Message      OpenAIMessage `json:"message"`
 		FinishReason string        `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage"`
+}
+
+// OpenAIModelStreamPayload is a single chunk of a streamed chat completion.
+type OpenAIModelStreamPayload struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Delta        OpenAIMessage `json:"delta"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	// Usage is only set on the final chunk, when requested with stream_options.
+	Usage *OpenAIUsage `json:"usage"`
 }
 
 type OpenAIModel struct {
-	modelId string
-	url     string
-	apiKey  string
-	filter  api.Filter
+	modelId       string
+	url           string
+	apiKey        string
+	requireAPIKey bool
+	timeout       time.Duration
+	parameters    api.GenerationParameters
+	limits        api.GenerationLimits
+	options       OpenAIOptions
+	client        *http.Client
+	prompts       *model.PromptBuilder
+	filter        api.Filter
 }
 
-func NewOpenAIModel(modelId, url, apiKey string) *OpenAIModel {
+func NewOpenAIModel(modelId, url, apiKey string, timeout time.Duration, parameters api.GenerationParameters, limits api.GenerationLimits, options OpenAIOptions) *OpenAIModel {
 	filter := api.NewFilter(nil, nil)
 
 	return &OpenAIModel{
-		modelId: modelId,
-		url:     url,
-		apiKey:  apiKey,
-		filter:  filter,
+		modelId:       modelId,
+		url:           strings.TrimSuffix(url, "/"),
+		apiKey:        apiKey,
+		requireAPIKey: true,
+		timeout:       timeout,
+		parameters:    parameters,
+		limits:        limits,
+		options:       options,
+		client:        &http.Client{},
+		prompts:       model.DefaultPromptBuilder(),
+		filter:        filter,
 	}
 }
 
@@ -50,10 +151,100 @@ func (m *OpenAIModel) GetFilter() api.Filter {
 	return m.filter
 }
 
-func (m *OpenAIModel) Invoke(input api.ModelInput) (api.ModelResponse, error) {
+func (m *OpenAIModel) Invoke(ctx context.Context, input api.ModelInput) (api.ModelResponse, error) {
+	params := api.ResolveParameters(m.parameters, m.limits, input.Parameters)
+	ctx, cancel := model.WithTimeout(ctx, m.timeout, params.TimeLimit)
+	defer cancel()
+
+	resp, err := m.doRequest(ctx, input, params, false)
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	// Parse the JSON response into the APIResponse struct
+	var apiResp OpenAIModelResponsePayload
+	err = json.NewDecoder(resp.Body).Decode(&apiResp)
+	if err != nil {
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error decoding api response")
+	}
+	if len(apiResp.Choices) == 0 {
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, nil, "model returned no valid responses: %v", apiResp)
+	}
+	response := api.ModelResponse{}
+	response.Input = input.Prompt
+	response.Output = apiResp.Choices[0].Message.Content
+	response.RawOutput = apiResp.Choices[0].Message.Content
+	response.Usage = apiResp.Usage.toUsage()
+	if response.Usage.TotalTokens == 0 {
+		response.Usage = m.prompts.EstimateUsage(input, response.Output)
+	}
+	return response, err
+}
+
+func (m *OpenAIModel) InvokeStream(ctx context.Context, input api.ModelInput, onToken func(string) error) (api.ModelResponse, error) {
+	params := api.ResolveParameters(m.parameters, m.limits, input.Parameters)
+	ctx, cancel := model.WithTimeout(ctx, m.timeout, params.TimeLimit)
+	defer cancel()
+
+	resp, err := m.doRequest(ctx, input, params, true)
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	output := strings.Builder{}
+	usage := api.Usage{}
+	err = model.ReadSSE(resp.Body, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return io.EOF
+		}
+		var chunk OpenAIModelStreamPayload
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error decoding api response chunk")
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage.toUsage()
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			return nil
+		}
+		output.WriteString(chunk.Choices[0].Delta.Content)
+		return onToken(chunk.Choices[0].Delta.Content)
+	})
+	if err != nil {
+		return api.ModelResponse{}, err
+	}
+	if output.Len() == 0 {
+		return api.ModelResponse{}, api.NewError(api.ErrorCodeUpstreamUnavailable, nil, "model returned no valid responses")
+	}
+
+	response := api.ModelResponse{}
+	response.Input = input.Prompt
+	response.Output = output.String()
+	response.RawOutput = output.String()
+	response.Usage = usage
+	if response.Usage.TotalTokens == 0 {
+		response.Usage = m.prompts.EstimateUsage(input, response.Output)
+	}
+	return response, nil
+}
+
+func (u *OpenAIUsage) toUsage() api.Usage {
+	if u == nil {
+		return api.Usage{}
+	}
+	return api.Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
 
-	if input.APIKey == "" && m.apiKey == "" {
-		return api.ModelResponse{}, fmt.Errorf("api key is required, none provided")
+// doRequest sends the chat completion request and returns the response if the api call succeeded.
+func (m *OpenAIModel) doRequest(ctx context.Context, input api.ModelInput, params api.GenerationParameters, stream bool) (*http.Response, error) {
+	if m.requireAPIKey && input.APIKey == "" && m.apiKey == "" {
+		return nil, api.NewError(api.ErrorCodeAuthFailed, nil, "api key is required, none provided")
 	}
 
 	apiKey := m.apiKey
@@ -62,58 +253,72 @@ func (m *OpenAIModel) Invoke(input api.ModelInput) (api.ModelResponse, error) {
 	}
 
 	payload := OpenAIModelRequestPayload{
-		Model: m.modelId,
+		Model:       m.modelId,
+		Stream:      stream,
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		MaxTokens:   params.MaxTokens,
+		Stop:        params.Stop,
+	}
+	if stream && m.options.StreamUsage {
+		payload.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
+	}
+	if m.options.SystemPrompt != "" {
+		payload.Messages = append(payload.Messages, OpenAIMessage{Role: "system", Content: m.options.SystemPrompt})
+	}
+	if message, ok := m.prompts.ContextMessage(input); ok {
+		payload.Messages = append(payload.Messages, OpenAIMessage{Role: message.Role, Content: message.Content})
+	}
+	for _, message := range input.History {
+		payload.Messages = append(payload.Messages, OpenAIMessage{Role: message.Role, Content: message.Content})
 	}
 	payload.Messages = append(payload.Messages, OpenAIMessage{Role: "user", Content: input.Prompt})
 
 	// Convert the payload to JSON
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
-		//fmt.Println("Error encoding JSON:", err)
-		return api.ModelResponse{}, err
+		return nil, err
 	}
 
-	apiURL := m.url + "/v1/chat/completions"
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonPayload))
+	apiURL := m.url + m.options.PathPrefix + "/chat/completions"
+	if m.options.APIVersion != "" {
+		apiURL += "?api-version=" + url.QueryEscape(m.options.APIVersion)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		//fmt.Println("Error creating HTTP request:", err)
-		return api.ModelResponse{}, err
+		return nil, err
+	}
+
+	for k, v := range m.options.Headers {
+		req.Header.Set(k, v)
 	}
 
 	// Set the "Content-Type" header to "application/json"
 	req.Header.Set("Content-Type", "application/json")
 
-	// Set the "Authorization" header with the bearer token
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if apiKey != "" {
+		if m.options.APIKeyHeader != "" {
+			req.Header.Set(m.options.APIKeyHeader, apiKey)
+		} else {
+			// Set the "Authorization" header with the bearer token
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+	}
 	//req.Header.Set("Email", input.UserId)
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
 
 	// Make the API call
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := m.client.Do(req)
 	if err != nil {
-		//fmt.Println("Error making API request:", err)
-		return api.ModelResponse{}, err
+		return nil, api.NewError(api.ErrorCodeUpstreamUnavailable, err, "error making api request")
 	}
-	defer resp.Body.Close()
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return api.ModelResponse{}, fmt.Errorf("API request failed with status: %s", resp.Status)
+		resp.Body.Close()
+		return nil, api.ErrorFromStatus(resp.StatusCode, "API request failed with status: %s", resp.Status)
 	}
-
-	// Parse the JSON response into the APIResponse struct
-	var apiResp OpenAIModelResponsePayload
-	err = json.NewDecoder(resp.Body).Decode(&apiResp)
-	if err != nil {
-		fmt.Println("Error decoding API response:", err)
-		return api.ModelResponse{}, err
-	}
-	if len(apiResp.Choices) == 0 {
-		return api.ModelResponse{}, fmt.Errorf("model returned no valid responses: %v", apiResp)
-	}
-	response := api.ModelResponse{}
-	response.Input = input.Prompt
-	response.Output = apiResp.Choices[0].Message.Content
-	response.RawOutput = apiResp.Choices[0].Message.Content
-	return response, err
+	return resp, nil
 }
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+const (
+	defaultMaxContextLength = 8000
+	defaultContextTemplate  = "{{if .Context}}Context:\n{{.Context}}\n\n{{end}}{{.Prompt}}"
+)
+
+// PromptBuilder incorporates the context and conversation history of an input into what is
+// sent to a model.
+type PromptBuilder struct {
+	maxContextLength int
+	template         *template.Template
+}
+
+// promptData is passed to context templates.
+type promptData struct {
+	Prompt  string
+	Context string
+}
+
+func NewPromptBuilder(config api.ContextConfig) (*PromptBuilder, error) {
+	b := &PromptBuilder{
+		maxContextLength: config.MaxLength,
+	}
+	if b.maxContextLength <= 0 {
+		b.maxContextLength = defaultMaxContextLength
+	}
+	text := config.Template
+	if text == "" {
+		text = defaultContextTemplate
+	}
+	t, err := template.New("context").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid context template: %v", err)
+	}
+	// catch references to fields which do not exist now rather than on every request
+	if err := t.Execute(&strings.Builder{}, promptData{}); err != nil {
+		return nil, fmt.Errorf("invalid context template: %v", err)
+	}
+	b.template = t
+	return b, nil
+}
+
+// DefaultPromptBuilder returns a builder with the default context length and template.
+func DefaultPromptBuilder() *PromptBuilder {
+	b, _ := NewPromptBuilder(api.ContextConfig{})
+	return b
+}
+
+// Context returns the input's context, truncated to the maximum length.  Clients append
+// newer context to the end, so the start is dropped, at a line boundary if there is one
+// close to where it would otherwise be cut.
+func (b *PromptBuilder) Context(input api.ModelInput) string {
+	context := input.Context
+	if len(context) <= b.maxContextLength {
+		return context
+	}
+	context = context[len(context)-b.maxContextLength:]
+	if i := strings.IndexByte(context, '\n'); i >= 0 && i < len(context)/4 {
+		context = context[i+1:]
+	}
+	// drop any partial character left at the start
+	for len(context) > 0 && !utf8.RuneStart(context[0]) {
+		context = context[1:]
+	}
+	return context
+}
+
+// Prompt returns the prompt for models which only accept a single prompt.  The context is
+// rendered with the template and prefixed with a transcript of the conversation history,
+// if any.  Chat models should send the context and history as messages instead.
+func (b *PromptBuilder) Prompt(input api.ModelInput) string {
+	prompt := strings.Builder{}
+	if err := b.template.Execute(&prompt, promptData{Prompt: input.Prompt, Context: b.Context(input)}); err != nil {
+		log.Errorf("failed to render context template, sending the prompt without context: %v", err)
+		prompt.Reset()
+		prompt.WriteString(input.Prompt)
+	}
+	if len(input.History) == 0 {
+		return prompt.String()
+	}
+
+	t := strings.Builder{}
+	for _, message := range input.History {
+		t.WriteString(roleLabel(message.Role))
+		t.WriteString(": ")
+		t.WriteString(message.Content)
+		t.WriteString("\n\n")
+	}
+	t.WriteString(roleLabel(api.RoleUser))
+	t.WriteString(": ")
+	t.WriteString(prompt.String())
+	t.WriteString("\n\n")
+	t.WriteString(roleLabel(api.RoleAssistant))
+	t.WriteString(":")
+	return t.String()
+}
+
+// EstimateUsage estimates the usage of an invocation from the prompt built for the input
+// and the completion, for providers which do not report it.
+func (b *PromptBuilder) EstimateUsage(input api.ModelInput, completion string) api.Usage {
+	return api.EstimateUsage(b.Prompt(input), completion)
+}
+
+// ContextMessage returns the system message carrying the context for chat models, or
+// false if the input has no context.
+func (b *PromptBuilder) ContextMessage(input api.ModelInput) (api.Message, bool) {
+	context := b.Context(input)
+	if context == "" {
+		return api.Message{}, false
+	}
+	return api.Message{Role: api.RoleSystem, Content: "Context:\n" + context}, true
+}
+
+func roleLabel(role string) string {
+	switch role {
+	case api.RoleAssistant:
+		return "Assistant"
+	case api.RoleSystem:
+		return "System"
+	}
+	return "User"
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// ModelFactory creates a model from its configuration.  Factories should reject
+// configuration, including provider specific options, that they cannot honor.
+type ModelFactory func(config api.ModelConfig) (api.Model, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]ModelFactory{}
+)
+
+// RegisterProvider makes a model provider available by name for use in the config file.
+// It is intended to be called from the init function of the provider's package and panics
+// if a provider is registered twice.
+func RegisterProvider(name string, factory ModelFactory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if _, found := factories[name]; found {
+		panic(fmt.Sprintf("model provider %q registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// Providers returns the names of all registered model providers.
+func Providers() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	names := []string{}
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewModel creates a model using the factory registered for the configured provider.
+// Any inputFilters are applied ahead of the model's own input filters.
+func NewModel(config api.ModelConfig, inputFilters ...api.InputFilter) (api.Model, error) {
+	factoriesLock.RLock()
+	factory, found := factories[config.Provider]
+	factoriesLock.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown provider %q for model %q, valid providers: %q", config.Provider, config.ModelId, Providers())
+	}
+	if err := config.Parameters.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration for model %s/%s: %w", config.Provider, config.ModelId, err)
+	}
+	m, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration for model %s/%s: %w", config.Provider, config.ModelId, err)
+	}
+	if len(inputFilters) > 0 {
+		m = NewFiltered(m, inputFilters...)
+	}
+	if rate := config.CircuitBreaker.FailureRate; rate != 0 {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid configuration for model %s/%s: circuit breaker failure rate must be between 0 and 1", config.Provider, config.ModelId)
+		}
+		m = NewBreaker(config.Provider+"/"+config.ModelId, m, config.CircuitBreaker)
+	}
+	return m, nil
+}
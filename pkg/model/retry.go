@@ -0,0 +1,163 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/tracing"
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+// NewHTTPClient returns a client for calling model provider apis which traces requests and
+// retries failed requests as described by config.
+func NewHTTPClient(config api.RetryConfig) *http.Client {
+	transport := &tracing.Transport{Base: http.DefaultTransport}
+	if config.MaxRetries <= 0 {
+		return &http.Client{Transport: transport}
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaultInitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	return &http.Client{
+		Transport: &retryTransport{
+			base:   transport,
+			config: config,
+		},
+	}
+}
+
+// retryTransport retries requests which failed to connect or were rejected with a status
+// indicating the provider is temporarily unable to serve them.  Other errors are not retried,
+// as the request may have reached the provider, which could then generate twice.  Retries back off
+// exponentially with jitter and honor any Retry-After header.  Retries are abandoned,
+// returning the failed response, rather than waiting longer than the maximum backoff or
+// past the request's deadline, so a provider asking for a long wait is reported as rate
+// limited at once.
+type retryTransport struct {
+	base   http.RoundTripper
+	config api.RetryConfig
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if attempt >= t.config.MaxRetries || !isRetryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		if delay > t.config.MaxBackoff {
+			log.Debugf("not retrying request to %s, retry after %s exceeds the maximum backoff", req.URL.Host, delay)
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			log.Debugf("not retrying request to %s, backoff of %s exceeds the request deadline", req.URL.Host, delay)
+			return resp, err
+		}
+		if resp != nil {
+			log.Debugf("retrying request to %s in %s after status: %s", req.URL.Host, delay, resp.Status)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			log.Debugf("retrying request to %s in %s after error: %v", req.URL.Host, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func isRetryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// the body has been consumed and cannot be sent again
+		return false
+	}
+	if err != nil {
+		return isConnectError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isConnectError returns true if err is a failure to connect, so the request was never sent.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// backoff returns the Retry-After delay requested by the response if there is one, which
+// may exceed the maximum backoff, or else a jittered exponential backoff for the attempt.
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return delay
+		}
+	}
+	delay := t.config.MaxBackoff
+	if attempt < 32 {
+		if d := t.config.InitialBackoff << uint(attempt); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		delay := time.Until(t)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,196 @@
+package model
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// errRefused is the error of a request which failed to connect.
+var errRefused = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+// stubResponse is returned by a stubTransport, with err set to fail the request.
+type stubResponse struct {
+	status     int
+	retryAfter string
+	err        error
+}
+
+type stubTransport struct {
+	responses []stubResponse
+	calls     int
+}
+
+func (t *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := t.responses[len(t.responses)-1]
+	if t.calls < len(t.responses) {
+		r = t.responses[t.calls]
+	}
+	t.calls++
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	resp := &http.Response{
+		StatusCode: r.status,
+		Status:     http.StatusText(r.status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+	}
+	if r.retryAfter != "" {
+		resp.Header.Set("Retry-After", r.retryAfter)
+	}
+	return resp, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	config := api.RetryConfig{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+	tests := []struct {
+		name       string
+		responses  []stubResponse
+		timeout    time.Duration
+		wantStatus int
+		wantErr    bool
+		wantCalls  int
+	}{
+		{
+			name:       "success is not retried",
+			responses:  []stubResponse{{status: http.StatusOK}},
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+		{
+			name:       "unavailable is retried",
+			responses:  []stubResponse{{status: http.StatusServiceUnavailable}, {status: http.StatusOK}},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			name:       "connection errors are retried",
+			responses:  []stubResponse{{err: errRefused}, {status: http.StatusOK}},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			name:      "errors after connecting are not retried",
+			responses: []stubResponse{{err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}, {status: http.StatusOK}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "unexpected end of response is not retried",
+			responses: []stubResponse{{err: io.ErrUnexpectedEOF}, {status: http.StatusOK}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:       "bad request is not retried",
+			responses:  []stubResponse{{status: http.StatusBadRequest}},
+			wantStatus: http.StatusBadRequest,
+			wantCalls:  1,
+		},
+		{
+			name:       "retries are limited",
+			responses:  []stubResponse{{status: http.StatusBadGateway}},
+			wantStatus: http.StatusBadGateway,
+			wantCalls:  3,
+		},
+		{
+			name:       "short retry after is honored",
+			responses:  []stubResponse{{status: http.StatusTooManyRequests, retryAfter: "0"}, {status: http.StatusOK}},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			name:       "retry after longer than the maximum backoff fails at once",
+			responses:  []stubResponse{{status: http.StatusTooManyRequests, retryAfter: "3"}, {status: http.StatusOK}},
+			wantStatus: http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+		{
+			name:       "retry after date longer than the maximum backoff fails at once",
+			responses:  []stubResponse{{status: http.StatusTooManyRequests, retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}, {status: http.StatusOK}},
+			wantStatus: http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+		{
+			name:       "backoff past the deadline fails at once",
+			responses:  []stubResponse{{status: http.StatusServiceUnavailable}, {status: http.StatusOK}},
+			timeout:    time.Microsecond,
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  1,
+		},
+		{
+			name:      "connection errors are returned once retries are exhausted",
+			responses: []stubResponse{{err: errRefused}},
+			wantErr:   true,
+			wantCalls: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubTransport{responses: tt.responses}
+			transport := &retryTransport{base: stub, config: config}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			req, err := http.NewRequestWithContext(ctx, "POST", "http://model.example.com/generate", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			resp, err := transport.RoundTrip(req)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s, expected no more than the maximum backoff per retry", elapsed)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got status %d", resp.StatusCode)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if resp.StatusCode != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if stub.calls != tt.wantCalls {
+				t.Errorf("expected %d calls, got %d", tt.wantCalls, stub.calls)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	transport := &retryTransport{config: api.RetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 0, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{attempt: 2, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 100, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if delay := transport.backoff(tt.attempt, nil); delay < tt.min || delay > tt.max {
+				t.Errorf("attempt %d: backoff %s outside %s-%s", tt.attempt, delay, tt.min, tt.max)
+			}
+		}
+	}
+}
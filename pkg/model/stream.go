@@ -0,0 +1,60 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// maxStreamLineSize bounds the size of a single line in a streamed response.
+const maxStreamLineSize = 1024 * 1024
+
+// ReadSSE reads a server-sent-events stream, calling fn with the data of each event.
+// fn may return io.EOF to stop reading before the end of the stream.
+func ReadSSE(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+
+	var data []byte
+	dispatch := func() error {
+		if data == nil {
+			return nil
+		}
+		err := fn(data)
+		data = nil
+		return err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			if err := dispatch(); err != nil {
+				return ignoreEOF(err)
+			}
+			continue
+		}
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			// comments, event names and ids are not used by any provider
+			continue
+		}
+		value := bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))
+		if data == nil {
+			data = []byte{}
+		} else {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return ignoreEOF(dispatch())
+}
+
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// WithTimeout bounds ctx by the model's configured timeout and, for providers which cannot
+// enforce it server side, the requested generation time limit in seconds, whichever is shorter.
+func WithTimeout(ctx context.Context, timeout time.Duration, timeLimit *float64) (context.Context, context.CancelFunc) {
+	if timeLimit != nil && *timeLimit > 0 {
+		limit := time.Duration(*timeLimit * float64(time.Second))
+		if timeout <= 0 || limit < timeout {
+			timeout = limit
+		}
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,178 @@
+package quota
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+const (
+	dayFormat   = "2006-01-02"
+	monthFormat = "2006-01"
+)
+
+// Window is the state of a single quota over the current day or month.
+type Window struct {
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// Status is the state of a user's quotas, across all models and for each model with its own
+// quota.  Windows without a limit are omitted.
+type Status struct {
+	User    string                 `json:"user"`
+	Daily   *Window                `json:"daily,omitempty"`
+	Monthly *Window                `json:"monthly,omitempty"`
+	Models  map[string]ModelStatus `json:"models,omitempty"`
+}
+
+type ModelStatus struct {
+	Daily   *Window `json:"daily,omitempty"`
+	Monthly *Window `json:"monthly,omitempty"`
+}
+
+// counter is the tokens used in the current day and month.
+type counter struct {
+	day         string
+	dayTokens   int
+	month       string
+	monthTokens int
+}
+
+func (c *counter) roll(now time.Time) {
+	if day := now.Format(dayFormat); day != c.day {
+		c.day, c.dayTokens = day, 0
+	}
+	if month := now.Format(monthFormat); month != c.month {
+		c.month, c.monthTokens = month, 0
+	}
+}
+
+// Tracker tracks each user's token usage against their quotas.  Days and months are in UTC.
+type Tracker struct {
+	config api.QuotaConfig
+	now    func() time.Time
+
+	lock sync.Mutex
+	// usage is keyed by user and then model, the empty model counts usage of all models
+	usage map[string]map[string]*counter
+}
+
+func NewTracker(config api.QuotaConfig) *Tracker {
+	return &Tracker{
+		config: config,
+		now:    time.Now,
+		usage:  make(map[string]map[string]*counter),
+	}
+}
+
+func (t *Tracker) quota(user string) api.UserQuota {
+	if q, found := t.config.Users[user]; found {
+		return q
+	}
+	return t.config.Default
+}
+
+func (t *Tracker) counter(user, model string, now time.Time) *counter {
+	models, found := t.usage[user]
+	if !found {
+		models = make(map[string]*counter)
+		t.usage[user] = models
+	}
+	c, found := models[model]
+	if !found {
+		c = &counter{}
+		models[model] = c
+	}
+	c.roll(now)
+	return c
+}
+
+// Check returns an error if the user using another tokens tokens of model would exceed any
+// of their quotas.
+func (t *Tracker) Check(user, model string, tokens int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := t.now().UTC()
+
+	q := t.quota(user)
+	if err := check(t.counter(user, "", now), q.TokenQuota, tokens, "all models"); err != nil {
+		return err
+	}
+	if mq, found := q.Models[model]; found {
+		return check(t.counter(user, model, now), mq, tokens, model)
+	}
+	return nil
+}
+
+func check(c *counter, q api.TokenQuota, tokens int, name string) error {
+	if q.Daily > 0 && c.dayTokens+tokens > q.Daily {
+		return api.NewError(api.ErrorCodeQuotaExceeded, nil, "daily quota of %d tokens for %s exceeded, %d used", q.Daily, name, c.dayTokens)
+	}
+	if q.Monthly > 0 && c.monthTokens+tokens > q.Monthly {
+		return api.NewError(api.ErrorCodeQuotaExceeded, nil, "monthly quota of %d tokens for %s exceeded, %d used", q.Monthly, name, c.monthTokens)
+	}
+	return nil
+}
+
+// Debit records the user using tokens tokens of model at the given time.  Usage from
+// before the current day or month is ignored by the corresponding quotas.
+func (t *Tracker) Debit(user, model string, tokens int, at time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := t.now().UTC()
+	at = at.UTC()
+
+	for _, name := range []string{"", model} {
+		c := t.counter(user, name, now)
+		if at.Format(dayFormat) == c.day {
+			c.dayTokens += tokens
+		}
+		if at.Format(monthFormat) == c.month {
+			c.monthTokens += tokens
+		}
+	}
+}
+
+// Status returns the state of the user's quotas.
+func (t *Tracker) Status(user string) Status {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := t.now().UTC()
+
+	q := t.quota(user)
+	status := Status{User: user}
+	status.Daily, status.Monthly = windows(t.counter(user, "", now), q.TokenQuota, now)
+
+	for model, mq := range q.Models {
+		if status.Models == nil {
+			status.Models = make(map[string]ModelStatus)
+		}
+		ms := ModelStatus{}
+		ms.Daily, ms.Monthly = windows(t.counter(user, model, now), mq, now)
+		status.Models[model] = ms
+	}
+	return status
+}
+
+func windows(c *counter, q api.TokenQuota, now time.Time) (*Window, *Window) {
+	var daily, monthly *Window
+	if q.Daily > 0 {
+		daily = newWindow(q.Daily, c.dayTokens, time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC))
+	}
+	if q.Monthly > 0 {
+		monthly = newWindow(q.Monthly, c.monthTokens, time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	return daily, monthly
+}
+
+func newWindow(limit, used int, reset time.Time) *Window {
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &Window{Limit: limit, Used: used, Remaining: remaining, Reset: reset}
+}
@@ -0,0 +1,252 @@
+package quota
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+const (
+	llama2 = "ollama/llama2"
+	gpt    = "openai/gpt-3.5-turbo"
+)
+
+var config = api.QuotaConfig{
+	Default: api.UserQuota{
+		TokenQuota: api.TokenQuota{Daily: 100, Monthly: 1000},
+		Models:     map[string]api.TokenQuota{llama2: {Daily: 50}},
+	},
+	Users: map[string]api.UserQuota{
+		"bob": {TokenQuota: api.TokenQuota{Daily: 10}},
+	},
+}
+
+// debit is usage recorded ago before the clock's time.
+type debit struct {
+	user   string
+	model  string
+	tokens int
+	ago    time.Duration
+}
+
+func newTestTracker(clock *time.Time) *Tracker {
+	t := NewTracker(config)
+	t.now = func() time.Time { return *clock }
+	return t
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		debits []debit
+		// advance moves the clock after the debits
+		advance time.Duration
+		user    string
+		model   string
+		// tokens is the estimated input tokens checked
+		tokens  int
+		wantErr bool
+	}{
+		{
+			name:   "estimate within the daily quota",
+			debits: []debit{{user: "alice", model: gpt, tokens: 60}},
+			user:   "alice",
+			model:  gpt,
+			tokens: 40,
+		},
+		{
+			name:    "estimate exceeding the daily quota",
+			debits:  []debit{{user: "alice", model: gpt, tokens: 60}},
+			user:    "alice",
+			model:   gpt,
+			tokens:  41,
+			wantErr: true,
+		},
+		{
+			name:    "estimate exceeding the daily quota alone",
+			user:    "alice",
+			model:   gpt,
+			tokens:  101,
+			wantErr: true,
+		},
+		{
+			name:    "estimate exceeding the monthly quota",
+			debits:  []debit{{user: "alice", model: gpt, tokens: 950, ago: 24 * time.Hour}},
+			user:    "alice",
+			model:   gpt,
+			tokens:  51,
+			wantErr: true,
+		},
+		{
+			name:    "estimate exceeding a model's quota",
+			debits:  []debit{{user: "alice", model: llama2, tokens: 30}},
+			user:    "alice",
+			model:   llama2,
+			tokens:  21,
+			wantErr: true,
+		},
+		{
+			name:   "a model's quota does not limit other models",
+			debits: []debit{{user: "alice", model: llama2, tokens: 30}},
+			user:   "alice",
+			model:  gpt,
+			tokens: 21,
+		},
+		{
+			name:    "usage of other models counts towards the model's overall quota",
+			debits:  []debit{{user: "alice", model: gpt, tokens: 90}},
+			user:    "alice",
+			model:   llama2,
+			tokens:  11,
+			wantErr: true,
+		},
+		{
+			name:    "a user's quota overrides the default",
+			debits:  []debit{{user: "bob", model: gpt, tokens: 5}},
+			user:    "bob",
+			model:   gpt,
+			tokens:  6,
+			wantErr: true,
+		},
+		{
+			name:   "a user's quota replaces the default model quotas",
+			user:   "bob",
+			model:  llama2,
+			tokens: 10,
+		},
+		{
+			name:   "usage by other users is not counted",
+			debits: []debit{{user: "alice", model: gpt, tokens: 100}},
+			user:   "carol",
+			model:  gpt,
+			tokens: 100,
+		},
+		{
+			name:    "the daily quota is available again the next day",
+			debits:  []debit{{user: "alice", model: gpt, tokens: 100}},
+			advance: 12 * time.Hour,
+			user:    "alice",
+			model:   gpt,
+			tokens:  100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := time.Date(2023, 1, 15, 12, 0, 0, 0, time.UTC)
+			tracker := newTestTracker(&clock)
+			for _, d := range tt.debits {
+				tracker.Debit(d.user, d.model, d.tokens, clock.Add(-d.ago))
+			}
+			clock = clock.Add(tt.advance)
+
+			err := tracker.Check(tt.user, tt.model, tt.tokens)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			var apiErr *api.Error
+			if err != nil && (!errors.As(err, &apiErr) || apiErr.Code != api.ErrorCodeQuotaExceeded) {
+				t.Errorf("got error %v, want quota_exceeded", err)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tomorrow := time.Date(2023, 1, 31, 0, 0, 0, 0, time.UTC)
+	nextMonth := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		debits []debit
+		// advance moves the clock after the debits
+		advance time.Duration
+		user    string
+		want    Status
+	}{
+		{
+			name: "usage of all models and of models with their own quota",
+			debits: []debit{
+				{user: "alice", model: gpt, tokens: 20},
+				{user: "alice", model: llama2, tokens: 30},
+				{user: "alice", model: llama2, tokens: 5, ago: 24 * time.Hour},
+			},
+			user: "alice",
+			want: Status{
+				User:    "alice",
+				Daily:   &Window{Limit: 100, Used: 50, Remaining: 50, Reset: tomorrow},
+				Monthly: &Window{Limit: 1000, Used: 55, Remaining: 945, Reset: nextMonth},
+				Models: map[string]ModelStatus{
+					llama2: {Daily: &Window{Limit: 50, Used: 30, Remaining: 20, Reset: tomorrow}},
+				},
+			},
+		},
+		{
+			name:   "remaining is never negative",
+			debits: []debit{{user: "bob", model: gpt, tokens: 15}},
+			user:   "bob",
+			want: Status{
+				User:  "bob",
+				Daily: &Window{Limit: 10, Used: 15, Remaining: 0, Reset: tomorrow},
+			},
+		},
+		{
+			name:    "the daily window resets at midnight",
+			debits:  []debit{{user: "alice", model: gpt, tokens: 20}},
+			advance: 12 * time.Hour,
+			user:    "alice",
+			want: Status{
+				User:    "alice",
+				Daily:   &Window{Limit: 100, Used: 0, Remaining: 100, Reset: nextMonth},
+				Monthly: &Window{Limit: 1000, Used: 20, Remaining: 980, Reset: nextMonth},
+				Models: map[string]ModelStatus{
+					llama2: {Daily: &Window{Limit: 50, Used: 0, Remaining: 50, Reset: nextMonth}},
+				},
+			},
+		},
+		{
+			name:    "the monthly window resets at the start of the month",
+			debits:  []debit{{user: "alice", model: gpt, tokens: 20}},
+			advance: 36 * time.Hour,
+			user:    "alice",
+			want: Status{
+				User:    "alice",
+				Daily:   &Window{Limit: 100, Used: 0, Remaining: 100, Reset: nextMonth.AddDate(0, 0, 1)},
+				Monthly: &Window{Limit: 1000, Used: 0, Remaining: 1000, Reset: nextMonth.AddDate(0, 1, 0)},
+				Models: map[string]ModelStatus{
+					llama2: {Daily: &Window{Limit: 50, Used: 0, Remaining: 50, Reset: nextMonth.AddDate(0, 0, 1)}},
+				},
+			},
+		},
+		{
+			name: "usage from an earlier month is ignored",
+			debits: []debit{
+				{user: "alice", model: gpt, tokens: 20, ago: 31 * 24 * time.Hour},
+			},
+			user: "alice",
+			want: Status{
+				User:    "alice",
+				Daily:   &Window{Limit: 100, Used: 0, Remaining: 100, Reset: tomorrow},
+				Monthly: &Window{Limit: 1000, Used: 0, Remaining: 1000, Reset: nextMonth},
+				Models: map[string]ModelStatus{
+					llama2: {Daily: &Window{Limit: 50, Used: 0, Remaining: 50, Reset: tomorrow}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := time.Date(2023, 1, 30, 12, 0, 0, 0, time.UTC)
+			tracker := newTestTracker(&clock)
+			for _, d := range tt.debits {
+				tracker.Debit(d.user, d.model, d.tokens, clock.Add(-d.ago))
+			}
+			clock = clock.Add(tt.advance)
+
+			if got := tracker.Status(tt.user); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got status %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
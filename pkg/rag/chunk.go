@@ -0,0 +1,163 @@
+package rag
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPassageLength is the length in bytes above which sections are split into several
+// passages at paragraph boundaries.
+const maxPassageLength = 2000
+
+type format int
+
+const (
+	formatMarkdown format = iota
+	formatAsciiDoc
+	formatYAML
+)
+
+// formats maps the extensions of the files which are indexed to their format.
+var formats = map[string]format{
+	".md":       formatMarkdown,
+	".markdown": formatMarkdown,
+	".adoc":     formatAsciiDoc,
+	".asciidoc": formatAsciiDoc,
+	".yaml":     formatYAML,
+	".yml":      formatYAML,
+}
+
+// section is a titled part of a document.
+type section struct {
+	title string
+	text  string
+}
+
+// splitDocument splits a document into passages, one per section unless the section is
+// too long.  Markdown and AsciiDoc are split at headings and YAML at document separators.
+func splitDocument(path, content string, f format) []Passage {
+	var sections []section
+	switch f {
+	case formatYAML:
+		sections = splitYAML(path, content)
+	default:
+		sections = splitHeadings(path, content, f)
+	}
+
+	passages := []Passage{}
+	for _, s := range sections {
+		for _, text := range splitLong(s.text) {
+			passages = append(passages, Passage{Source: path, Title: s.title, Text: text})
+		}
+	}
+	return passages
+}
+
+// splitHeadings starts a new section at each heading outside of code blocks.
+func splitHeadings(path, content string, f format) []section {
+	sections := []section{}
+	current := section{title: filepath.Base(path)}
+	lines := []string{}
+	flush := func() {
+		if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+			current.text = text
+			sections = append(sections, current)
+		}
+		lines = nil
+	}
+
+	inCode := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if isCodeDelimiter(trimmed, f) {
+			inCode = !inCode
+		}
+		if !inCode {
+			if title, ok := heading(trimmed, f); ok {
+				flush()
+				current = section{title: title}
+			}
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return sections
+}
+
+func isCodeDelimiter(line string, f format) bool {
+	if f == formatAsciiDoc {
+		return line == "----" || line == "...."
+	}
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// heading returns the title of a markdown "# Title" or asciidoc "== Title" heading line.
+func heading(line string, f format) (string, bool) {
+	marker := byte('#')
+	if f == formatAsciiDoc {
+		marker = '='
+	}
+	level := 0
+	for level < len(line) && line[level] == marker {
+		level++
+	}
+	if level == 0 || level > 6 || level == len(line) || line[level] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(line[level:]), true
+}
+
+// splitYAML starts a new section at each "---" document separator.  Sections are titled
+// by their leading comment, if any, otherwise by the file name.
+func splitYAML(path, content string) []section {
+	sections := []section{}
+	documents := []string{}
+	current := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "---") {
+			documents = append(documents, strings.Join(current, "\n"))
+			current = nil
+			continue
+		}
+		current = append(current, line)
+	}
+	documents = append(documents, strings.Join(current, "\n"))
+
+	for _, document := range documents {
+		text := strings.TrimSpace(document)
+		if text == "" {
+			continue
+		}
+		title := filepath.Base(path)
+		if strings.HasPrefix(text, "#") {
+			comment, _, _ := strings.Cut(text, "\n")
+			title = strings.TrimSpace(strings.TrimLeft(comment, "#"))
+		}
+		sections = append(sections, section{title: title, text: text})
+	}
+	return sections
+}
+
+// splitLong splits text longer than maxPassageLength at blank lines.  Paragraphs which
+// are themselves too long are kept whole rather than cut mid sentence.
+func splitLong(text string) []string {
+	if len(text) <= maxPassageLength {
+		return []string{text}
+	}
+	parts := []string{}
+	current := strings.Builder{}
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if current.Len() > 0 && current.Len()+len(paragraph)+2 > maxPassageLength {
+			parts = append(parts, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+	}
+	if s := strings.TrimSpace(current.String()); s != "" {
+		parts = append(parts, s)
+	}
+	return parts
+}
@@ -0,0 +1,76 @@
+package rag
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitDocument(t *testing.T) {
+	long := strings.Repeat("a", 1200)
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		format  format
+		want    []Passage
+	}{
+		{
+			name:    "markdown headings",
+			path:    "docs/deploy.md",
+			content: "intro\n# Deployments\nCreate one.\n## Scaling\nSet replicas.\n",
+			format:  formatMarkdown,
+			want: []Passage{
+				{Source: "docs/deploy.md", Title: "deploy.md", Text: "intro"},
+				{Source: "docs/deploy.md", Title: "Deployments", Text: "# Deployments\nCreate one."},
+				{Source: "docs/deploy.md", Title: "Scaling", Text: "## Scaling\nSet replicas."},
+			},
+		},
+		{
+			name:    "markdown headings in code blocks are ignored",
+			path:    "run.md",
+			content: "# Run\n```\n# not a heading\n```\n",
+			format:  formatMarkdown,
+			want: []Passage{
+				{Source: "run.md", Title: "Run", Text: "# Run\n```\n# not a heading\n```"},
+			},
+		},
+		{
+			name:    "asciidoc headings",
+			path:    "guide.adoc",
+			content: "== Install\nRun the installer.\n----\n== not a heading\n----\n=== Verify\nCheck it.",
+			format:  formatAsciiDoc,
+			want: []Passage{
+				{Source: "guide.adoc", Title: "Install", Text: "== Install\nRun the installer.\n----\n== not a heading\n----"},
+				{Source: "guide.adoc", Title: "Verify", Text: "=== Verify\nCheck it."},
+			},
+		},
+		{
+			name:    "yaml documents titled by their leading comment",
+			path:    "examples/app.yaml",
+			content: "# A service\nkind: Service\n---\nkind: Deployment\n---\n",
+			format:  formatYAML,
+			want: []Passage{
+				{Source: "examples/app.yaml", Title: "A service", Text: "# A service\nkind: Service"},
+				{Source: "examples/app.yaml", Title: "app.yaml", Text: "kind: Deployment"},
+			},
+		},
+		{
+			name:    "long sections are split at paragraphs",
+			path:    "long.md",
+			content: "# Long\n" + long + "\n\n" + long,
+			format:  formatMarkdown,
+			want: []Passage{
+				{Source: "long.md", Title: "Long", Text: "# Long\n" + long},
+				{Source: "long.md", Title: "Long", Text: long},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitDocument(tt.path, tt.content, tt.format); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package rag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	indexVersion = 1
+
+	// BM25 parameters, the commonly used defaults.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// stopWords are too common to help rank passages.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "how": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "that": true, "the": true, "this": true, "to": true, "was": true, "what": true,
+	"with": true, "write": true, "you": true,
+}
+
+// Passage is a section of a document in the corpus.
+type Passage struct {
+	// Source is the path of the document, relative to the indexed directory.
+	Source string `json:"source"`
+	Title  string `json:"title"`
+	Text   string `json:"text"`
+	// Terms counts the occurrences of each term in the passage and Length is their total.
+	Terms  map[string]int `json:"terms"`
+	Length int            `json:"length"`
+}
+
+// Result is a passage matching a query.
+type Result struct {
+	Passage
+	Score float64
+}
+
+// Index is a BM25 index of passages.
+type Index struct {
+	passages      []Passage
+	postings      map[string][]posting
+	averageLength float64
+}
+
+type posting struct {
+	passage int
+	count   int
+}
+
+// indexFile is the format in which indexes are saved.
+type indexFile struct {
+	Version  int       `json:"version"`
+	Passages []Passage `json:"passages"`
+}
+
+// BuildIndex indexes the Markdown, AsciiDoc and YAML files under dir.
+func BuildIndex(dir string) (*Index, error) {
+	passages := []Passage{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		f, found := formats[strings.ToLower(filepath.Ext(path))]
+		if d.IsDir() || !found {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		split := splitDocument(rel, string(content), f)
+		log.Debugf("indexed %d passages from %s", len(split), rel)
+		passages = append(passages, split...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error indexing %s: %v", dir, err)
+	}
+	for i := range passages {
+		passages[i].Terms = map[string]int{}
+		// titles are indexed with the text, they often name exactly what the passage covers
+		for _, term := range tokenize(passages[i].Title + "\n" + passages[i].Text) {
+			passages[i].Terms[term]++
+			passages[i].Length++
+		}
+	}
+	return newIndex(passages), nil
+}
+
+// LoadIndex reads an index saved with Save.
+func LoadIndex(path string) (*Index, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := indexFile{}
+	if err := json.Unmarshal(buf, &file); err != nil {
+		return nil, fmt.Errorf("error decoding index %s: %v", path, err)
+	}
+	if file.Version != indexVersion {
+		return nil, fmt.Errorf("index %s has version %d, expected %d, rebuild it with wisdom index", path, file.Version, indexVersion)
+	}
+	return newIndex(file.Passages), nil
+}
+
+func newIndex(passages []Passage) *Index {
+	index := &Index{
+		passages: passages,
+		postings: map[string][]posting{},
+	}
+	total := 0
+	for i, p := range passages {
+		total += p.Length
+		for term, count := range p.Terms {
+			index.postings[term] = append(index.postings[term], posting{passage: i, count: count})
+		}
+	}
+	if len(passages) > 0 {
+		index.averageLength = float64(total) / float64(len(passages))
+	}
+	return index
+}
+
+// Save writes the index to path, replacing any existing file.
+func (index *Index) Save(path string) error {
+	buf, err := json.Marshal(indexFile{Version: indexVersion, Passages: index.passages})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Len returns the number of passages in the index.
+func (index *Index) Len() int {
+	return len(index.passages)
+}
+
+// Search returns up to k passages matching the query, best first.
+func (index *Index) Search(query string, k int) []Result {
+	n := float64(len(index.passages))
+	scores := map[int]float64{}
+	seen := map[string]bool{}
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := index.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.count)
+			norm := 1 - bm25B + bm25B*float64(index.passages[p.passage].Length)/index.averageLength
+			scores[p.passage] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	matches := make([]int, 0, len(scores))
+	for i := range scores {
+		matches = append(matches, i)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if scores[matches[i]] != scores[matches[j]] {
+			return scores[matches[i]] > scores[matches[j]]
+		}
+		// keep the order of equally scored passages stable
+		return matches[i] < matches[j]
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	results := make([]Result, 0, len(matches))
+	for _, i := range matches {
+		results = append(results, Result{Passage: index.passages[i], Score: scores[i]})
+	}
+	return results
+}
+
+// tokenize splits text into lower case terms at anything other than letters and digits,
+// dropping stop words and single characters.
+func tokenize(text string) []string {
+	terms := []string{}
+	for _, field := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(field) < 2 || stopWords[field] {
+			continue
+		}
+		terms = append(terms, field)
+	}
+	return terms
+}
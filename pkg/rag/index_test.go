@@ -0,0 +1,122 @@
+package rag
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeCorpus writes the files, keyed by their path relative to a new directory, and
+// returns the directory.
+func writeCorpus(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var corpus = map[string]string{
+	"deployment.md": "# Deployments\nA deployment runs replicas of a pod. Scale a deployment by setting replicas.\n",
+	"service.md":    "# Services\nA service exposes pods on a stable address. Select the pods of a service with labels.\n",
+	"route.adoc":    "== Routes\nA route exposes a service outside the cluster.\n",
+	"notes.txt":     "deployment deployment deployment\n",
+	".hidden/x.md":  "# Deployments\ndeployment deployment deployment\n",
+}
+
+func TestSearch(t *testing.T) {
+	index, err := BuildIndex(writeCorpus(t, corpus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Len() != 3 {
+		t.Fatalf("indexed %d passages, want 3, ignoring unsupported and hidden files", index.Len())
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		k     int
+		want  []string
+	}{
+		{
+			name:  "best match first",
+			query: "how do I scale a deployment",
+			k:     3,
+			want:  []string{"Deployments"},
+		},
+		{
+			name:  "more occurrences rank higher",
+			query: "service",
+			k:     3,
+			want:  []string{"Services", "Routes"},
+		},
+		{
+			name:  "more matching terms rank higher",
+			query: "service route",
+			k:     3,
+			want:  []string{"Routes", "Services"},
+		},
+		{
+			name:  "limited to k results",
+			query: "service",
+			k:     1,
+			want:  []string{"Services"},
+		},
+		{
+			name:  "stop words match nothing",
+			query: "what is the",
+			k:     3,
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := index.Search(tt.query, tt.k)
+			got := []string{}
+			for i, r := range results {
+				got = append(got, r.Title)
+				if i > 0 && r.Score > results[i-1].Score {
+					t.Errorf("result %d scores %f, more than the result before it", i, r.Score)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveLoadIndex(t *testing.T) {
+	index, err := BuildIndex(writeCorpus(t, corpus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "index.json")
+	if err := index.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := "service route"
+	if got, want := loaded.Search(query, 3), index.Search(query, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded index returned %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version": 0, "passages": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(path); err == nil {
+		t.Error("loaded an index of another version, want an error")
+	}
+}
@@ -0,0 +1,82 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+const (
+	defaultTopK      = 3
+	defaultMaxTokens = 1000
+)
+
+// Retriever provides the input filters retrieving the passages of an index most relevant to
+// the prompt and adding them ahead of the prompt.  Retrieve must run before the prompt is
+// rendered with a template, so that the user's prompt is searched, and Augment after, so
+// that the template's instructions apply to the user's prompt rather than the passages.
+type Retriever struct {
+	index     *Index
+	topK      int
+	maxTokens int
+	minScore  float64
+}
+
+func NewRetriever(index *Index, config api.RetrievalConfig) *Retriever {
+	r := &Retriever{
+		index:     index,
+		topK:      config.TopK,
+		maxTokens: config.MaxTokens,
+		minScore:  config.MinScore,
+	}
+	if r.topK <= 0 {
+		r.topK = defaultTopK
+	}
+	if r.maxTokens <= 0 {
+		r.maxTokens = defaultMaxTokens
+	}
+	return r
+}
+
+// Retrieve records the best matching passages on the input, with their sources, skipping
+// those which would take the passages over the token budget.
+func (r *Retriever) Retrieve(ctx context.Context, input api.ModelInput) (api.ModelInput, error) {
+	passages := strings.Builder{}
+	sources := []api.Source{}
+	tokens := 0
+	for _, result := range r.index.Search(input.Prompt, r.topK) {
+		if result.Score < r.minScore {
+			break
+		}
+		passage := fmt.Sprintf("[%d] %s (%s)\n%s\n\n", len(sources)+1, result.Title, result.Source, result.Text)
+		n := api.EstimateTokens(passage)
+		if tokens+n > r.maxTokens {
+			log.Debugf("skipping passage %s (%s) of %d tokens, exceeds the retrieval token budget", result.Title, result.Source, n)
+			continue
+		}
+		tokens += n
+		passages.WriteString(passage)
+		sources = append(sources, api.Source{Path: result.Source, Title: result.Title, Score: result.Score})
+	}
+	if len(sources) == 0 {
+		return input, nil
+	}
+	log.Debugf("retrieved %d passages of %d tokens for the prompt", len(sources), tokens)
+	input.Passages = passages.String()
+	input.Sources = sources
+	return input, nil
+}
+
+// Augment adds the passages recorded by Retrieve ahead of the prompt.
+func (r *Retriever) Augment(ctx context.Context, input api.ModelInput) (api.ModelInput, error) {
+	if input.Passages == "" {
+		return input, nil
+	}
+	input.Prompt = "Use the following documentation where it is relevant.\n\n" + input.Passages + input.Prompt
+	input.Passages = ""
+	return input, nil
+}
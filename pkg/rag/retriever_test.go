@@ -0,0 +1,93 @@
+package rag
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+func TestRetriever(t *testing.T) {
+	index, err := BuildIndex(writeCorpus(t, corpus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		config      api.RetrievalConfig
+		prompt      string
+		wantSources []string
+	}{
+		{
+			name:        "top passages in order",
+			prompt:      "service",
+			wantSources: []string{"service.md", "route.adoc"},
+		},
+		{
+			name:        "limited to top k",
+			config:      api.RetrievalConfig{TopK: 1},
+			prompt:      "service",
+			wantSources: []string{"service.md"},
+		},
+		{
+			name: "passages over the token budget are skipped",
+			// the service passage is estimated at 31 tokens and the route passage at 21
+			config:      api.RetrievalConfig{MaxTokens: 25},
+			prompt:      "service",
+			wantSources: []string{"route.adoc"},
+		},
+		{
+			name:        "passages below the minimum score are dropped",
+			config:      api.RetrievalConfig{MinScore: 0.6},
+			prompt:      "service",
+			wantSources: []string{"service.md"},
+		},
+		{
+			name:        "nothing matching leaves the prompt alone",
+			prompt:      "hello",
+			wantSources: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRetriever(index, tt.config)
+			input, err := r.Retrieve(context.Background(), api.ModelInput{Prompt: tt.prompt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sources := []string{}
+			for _, s := range input.Sources {
+				sources = append(sources, s.Path)
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("got sources %q, want %q", sources, tt.wantSources)
+			}
+			if input.Prompt != tt.prompt {
+				t.Errorf("retrieve changed the prompt to %q", input.Prompt)
+			}
+
+			// the template is rendered between retrieving and adding the passages
+			input.Prompt = "Respond in yaml: " + input.Prompt
+			input, err = r.Augment(context.Background(), input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.wantSources) == 0 {
+				if input.Prompt != "Respond in yaml: "+tt.prompt {
+					t.Errorf("got prompt %q, want it unchanged", input.Prompt)
+				}
+				return
+			}
+			if !strings.HasPrefix(input.Prompt, "Use the following documentation") || !strings.HasSuffix(input.Prompt, "\n\nRespond in yaml: "+tt.prompt) {
+				t.Errorf("got prompt %q, want the passages ahead of the rendered prompt", input.Prompt)
+			}
+			for _, s := range input.Sources {
+				if !strings.Contains(input.Prompt, "("+s.Path+")") {
+					t.Errorf("prompt %q does not include the passage from %s", input.Prompt, s.Path)
+				}
+			}
+		})
+	}
+}
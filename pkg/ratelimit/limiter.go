@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// Result describes the outcome of a request against a rate limit.
+type Result struct {
+	Allowed bool
+	// Limit is the burst size of the limiting bucket and Remaining the whole requests left in it.
+	Limit     int
+	Remaining int
+	// Reset is the time until the limiting bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until a request would be allowed, when it was not.
+	RetryAfter time.Duration
+}
+
+// bucket is a token bucket refilled continuously at rate tokens per second up to burst.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit api.RateLimit, now time.Time) *bucket {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(limit.RequestsPerMinute)))
+	}
+	return &bucket{
+		rate:   limit.RequestsPerMinute / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *bucket) result(allowed bool) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     int(b.burst),
+		Remaining: int(math.Max(0, math.Floor(b.tokens))),
+		Reset:     secondsToDuration((b.burst - b.tokens) / b.rate),
+	}
+	if !allowed {
+		r.RetryAfter = secondsToDuration((1 - b.tokens) / b.rate)
+	}
+	return r
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// Limiter applies a token bucket per user and a global token bucket shared by all users.
+type Limiter struct {
+	config api.RateLimitConfig
+	now    func() time.Time
+
+	lock   sync.Mutex
+	global *bucket
+	users  map[string]*bucket
+}
+
+func NewLimiter(config api.RateLimitConfig) *Limiter {
+	return newLimiter(config, time.Now)
+}
+
+// newLimiter returns a limiter reading the current time from now.
+func newLimiter(config api.RateLimitConfig, now func() time.Time) *Limiter {
+	l := &Limiter{
+		config: config,
+		now:    now,
+		users:  make(map[string]*bucket),
+	}
+	if config.Global.RequestsPerMinute > 0 {
+		l.global = newBucket(config.Global, now())
+	}
+	return l
+}
+
+// Allow takes a token from the user's bucket and the global bucket if both have one
+// available.  The result describes the user's bucket unless the global bucket refused the
+// request.
+func (l *Limiter) Allow(user string) Result {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+
+	userBucket := l.userBucket(user, now)
+	if l.global != nil {
+		l.global.refill(now)
+	}
+	if userBucket != nil {
+		userBucket.refill(now)
+		if userBucket.tokens < 1 {
+			return userBucket.result(false)
+		}
+	}
+	if l.global != nil && l.global.tokens < 1 {
+		return l.global.result(false)
+	}
+
+	if l.global != nil {
+		l.global.tokens--
+	}
+	if userBucket != nil {
+		userBucket.tokens--
+		return userBucket.result(true)
+	}
+	if l.global != nil {
+		return l.global.result(true)
+	}
+	return Result{Allowed: true}
+}
+
+// userBucket returns the user's bucket, or nil if the user is not rate limited.
+func (l *Limiter) userBucket(user string, now time.Time) *bucket {
+	if b, found := l.users[user]; found {
+		return b
+	}
+	limit, found := l.config.Users[user]
+	if !found {
+		limit = l.config.Default
+	}
+	if limit.RequestsPerMinute <= 0 {
+		return nil
+	}
+	b := newBucket(limit, now)
+	l.users[user] = b
+	return b
+}
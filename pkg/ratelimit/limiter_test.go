@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+func TestLimiter(t *testing.T) {
+	// step is a request by user after advancing the clock
+	type step struct {
+		advance       time.Duration
+		user          string
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}
+	tests := []struct {
+		name   string
+		config api.RateLimitConfig
+		steps  []step
+	}{
+		{
+			name:   "unlimited without limits",
+			config: api.RateLimitConfig{},
+			steps: []step{
+				{user: "alice", wantAllowed: true},
+				{user: "alice", wantAllowed: true},
+			},
+		},
+		{
+			name:   "burst then refill",
+			config: api.RateLimitConfig{Default: api.RateLimit{RequestsPerMinute: 60, Burst: 2}},
+			steps: []step{
+				{user: "alice", wantAllowed: true, wantRemaining: 1},
+				{user: "alice", wantAllowed: true, wantRemaining: 0},
+				{user: "alice", wantAllowed: false, wantRemaining: 0, wantRetry: time.Second},
+				{advance: 500 * time.Millisecond, user: "alice", wantAllowed: false, wantRemaining: 0, wantRetry: 500 * time.Millisecond},
+				{advance: 500 * time.Millisecond, user: "alice", wantAllowed: true, wantRemaining: 0},
+				{advance: time.Hour, user: "alice", wantAllowed: true, wantRemaining: 1},
+			},
+		},
+		{
+			name:   "burst defaults to the requests per minute",
+			config: api.RateLimitConfig{Default: api.RateLimit{RequestsPerMinute: 2}},
+			steps: []step{
+				{user: "alice", wantAllowed: true, wantRemaining: 1},
+				{user: "alice", wantAllowed: true, wantRemaining: 0},
+				{user: "alice", wantAllowed: false, wantRemaining: 0, wantRetry: 30 * time.Second},
+			},
+		},
+		{
+			name: "users have separate buckets and overrides",
+			config: api.RateLimitConfig{
+				Default: api.RateLimit{RequestsPerMinute: 60, Burst: 1},
+				Users:   map[string]api.RateLimit{"bob": {RequestsPerMinute: 60, Burst: 2}},
+			},
+			steps: []step{
+				{user: "alice", wantAllowed: true, wantRemaining: 0},
+				{user: "alice", wantAllowed: false, wantRemaining: 0, wantRetry: time.Second},
+				{user: "bob", wantAllowed: true, wantRemaining: 1},
+				{user: "bob", wantAllowed: true, wantRemaining: 0},
+				{user: "carol", wantAllowed: true, wantRemaining: 0},
+			},
+		},
+		{
+			name: "global bucket is shared by all users",
+			config: api.RateLimitConfig{
+				Default: api.RateLimit{RequestsPerMinute: 60, Burst: 5},
+				Global:  api.RateLimit{RequestsPerMinute: 60, Burst: 2},
+			},
+			steps: []step{
+				{user: "alice", wantAllowed: true, wantRemaining: 4},
+				{user: "bob", wantAllowed: true, wantRemaining: 4},
+				{user: "carol", wantAllowed: false, wantRemaining: 0, wantRetry: time.Second},
+				{advance: time.Second, user: "carol", wantAllowed: true, wantRemaining: 4},
+			},
+		},
+		{
+			name: "refused requests take no tokens",
+			config: api.RateLimitConfig{
+				Default: api.RateLimit{RequestsPerMinute: 60, Burst: 1},
+				Global:  api.RateLimit{RequestsPerMinute: 60, Burst: 2},
+			},
+			steps: []step{
+				{user: "alice", wantAllowed: true, wantRemaining: 0},
+				{user: "alice", wantAllowed: false, wantRemaining: 0, wantRetry: time.Second},
+				{user: "bob", wantAllowed: true, wantRemaining: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+			l := newLimiter(tt.config, func() time.Time { return now })
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				r := l.Allow(s.user)
+				if r.Allowed != s.wantAllowed || r.Remaining != s.wantRemaining || r.RetryAfter != s.wantRetry {
+					t.Errorf("step %d: got allowed %v, remaining %d, retry after %s, want %v, %d, %s",
+						i, r.Allowed, r.Remaining, r.RetryAfter, s.wantAllowed, s.wantRemaining, s.wantRetry)
+				}
+			}
+		})
+	}
+}
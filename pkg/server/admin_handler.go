@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/usage"
+)
+
+// isAdmin checks the request has a valid bearer token for an admin user, writing an error
+// response and returning false if it does not.
+func (h *Handler) isAdmin(w http.ResponseWriter, r *http.Request, requestID string) bool {
+	username, ok := h.hasValidBearerToken(r)
+	if !ok {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeAuthFailed, nil, "no valid bearer token found"))
+		return false
+	}
+	if !h.AdminUsers[username] {
+		log.Debugf("user %s not in admin users", username)
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeForbidden, nil, "user %s is not an admin", username))
+		return false
+	}
+	return true
+}
+
+// UsageHandler reports usage by user and model between the from and to query parameters,
+// dates of the form 2006-01-02, optionally restricted with the user and model parameters.
+func (h *Handler) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	if !h.isAdmin(w, r, requestID) {
+		return
+	}
+	if h.UsageLedger == nil {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, nil, "usage is not being recorded"))
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := usage.ParseDateRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, err, "invalid date range"))
+		return
+	}
+	filter.User = query.Get("user")
+	filter.Model = query.Get("model")
+
+	report, err := h.UsageLedger.Report(filter)
+	if err != nil {
+		log.Errorf("failed to read usage ledger: %v", err)
+		writeError(w, requestID, "", err)
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(report); err != nil {
+		log.Errorf("failed to encode usage report: %v", err)
+		writeError(w, requestID, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/metrics"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
@@ -145,16 +146,26 @@ func (h *Handler) isAuthorized(w http.ResponseWriter, r *http.Request) (string,
 	return username, true
 }
 
-func (h *Handler) hasValidBearerToken(r *http.Request) bool {
+// hasValidBearerToken returns the username from the request's bearer token if it is valid
+// and the user is allowed.
+func (h *Handler) hasValidBearerToken(r *http.Request) (string, bool) {
+	username, ok := h.parseBearerToken(r)
+	if !ok {
+		metrics.AuthFailures.Inc()
+	}
+	return username, ok
+}
+
+func (h *Handler) parseBearerToken(r *http.Request) (string, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		log.Debug("no authorization header")
-		return false
+		return "", false
 	}
 
 	if !strings.HasPrefix(authHeader, "Bearer ") {
 		log.Debug("authorization header does not start with Bearer")
-		return false
+		return "", false
 	}
 
 	token := strings.TrimPrefix(authHeader, "Bearer ")
@@ -166,17 +177,17 @@ func (h *Handler) hasValidBearerToken(r *http.Request) bool {
 	if err != nil {
 		log.Errorf("failed to parse jwt token: %v", err)
 		if err == jwt.ErrSignatureInvalid {
-			return false
+			return "", false
 		}
-		return false
+		return "", false
 	}
 	if !tkn.Valid {
 		log.Debug("token is not valid")
-		return false
+		return "", false
 	}
 	if _, found := h.AllowedUsers[claims.Username]; !found {
 		log.Debugf("user %s not in allowed users", claims.Username)
-		return false
+		return claims.Username, false
 	}
-	return true
+	return claims.Username, true
 }
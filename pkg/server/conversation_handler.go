@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+// ConversationListHandler lists the requesting user's conversations.
+func (h *Handler) ConversationListHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, username, ok := h.conversationRequest(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, requestID, h.Conversations.List(username))
+}
+
+// ConversationHandler returns one of the requesting user's conversations.
+func (h *Handler) ConversationHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, username, ok := h.conversationRequest(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	conversation, found := h.Conversations.Get(username, id)
+	if !found {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeNotFound, nil, "unknown conversation %s", id))
+		return
+	}
+	writeJSON(w, requestID, conversation)
+}
+
+// ConversationDeleteHandler deletes one of the requesting user's conversations.
+func (h *Handler) ConversationDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	requestID, username, ok := h.conversationRequest(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	found, err := h.Conversations.Delete(username, id)
+	if err != nil {
+		log.Errorf("failed to delete conversation %s: %v", id, err)
+		writeError(w, requestID, "", err)
+		return
+	}
+	if !found {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeNotFound, nil, "unknown conversation %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// conversationRequest authorizes a request to the conversation endpoints, writing an error
+// response and returning false if it cannot be served.
+func (h *Handler) conversationRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	http.Header.Add(w.Header(), "Access-Control-Allow-Origin", "*")
+
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	username, ok := h.hasValidBearerToken(r)
+	if !ok {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeAuthFailed, nil, "no valid bearer token found"))
+		return requestID, "", false
+	}
+	if h.Conversations == nil {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, nil, "conversations are not enabled"))
+		return requestID, "", false
+	}
+	return requestID, username, true
+}
+
+func writeJSON(w http.ResponseWriter, requestID string, body interface{}) {
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		log.Errorf("failed to encode response: %v", err)
+		writeError(w, requestID, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/feedback"
+	"github.com/openshift/wisdom/pkg/usage"
+)
+
+// FeedbackHandler stores the user's feedback on a response they were served.
+func (h *Handler) FeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	http.Header.Add(w.Header(), "Access-Control-Allow-Origin", "*")
+
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	username, ok := h.hasValidBearerToken(r)
+	if !ok {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeAuthFailed, nil, "no valid bearer token found"))
+		return
+	}
+
+	var payload api.FeedbackPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, err, "invalid request payload"))
+		return
+	}
+	if payload.RequestID == "" {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, nil, "requestId is required"))
+		return
+	}
+	// feedback may only be given on responses served to the same user
+	response, found := h.Responses.Get(payload.RequestID)
+	if !found || response.User != username {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, nil, "unknown requestId %s", payload.RequestID))
+		return
+	}
+
+	fb := feedback.Feedback{
+		Time:              time.Now().UTC(),
+		RequestID:         payload.RequestID,
+		User:              username,
+		Model:             response.Model,
+		ConversationID:    payload.ConversationID,
+		Response:          payload.Response,
+		ResponseAccepted:  payload.ResponseAccepted,
+		CorrectedResponse: payload.CorrectedResponse,
+		UserComments:      payload.UserComments,
+	}
+	if fb.ConversationID == "" {
+		fb.ConversationID = response.ConversationID
+	}
+	if err := h.FeedbackStore.Add(fb); err != nil {
+		log.Errorf("failed to store feedback: %v", err)
+		writeError(w, requestID, "", err)
+		return
+	}
+	log.Debugf("stored feedback from user %s for request %s", username, payload.RequestID)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Feedback received."))
+}
+
+// FeedbackListHandler lists feedback as a JSON array, filtered by the optional from, to,
+// user and model query parameters.
+func (h *Handler) FeedbackListHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	list, ok := h.listFeedback(w, r, requestID)
+	if !ok {
+		return
+	}
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(list); err != nil {
+		log.Errorf("failed to encode feedback: %v", err)
+		writeError(w, requestID, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// FeedbackExportHandler exports feedback as a JSON lines file, filtered as by
+// FeedbackListHandler.
+func (h *Handler) FeedbackExportHandler(w http.ResponseWriter, r *http.Request) {
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	list, ok := h.listFeedback(w, r, requestID)
+	if !ok {
+		return
+	}
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	for _, fb := range list {
+		if err := encoder.Encode(fb); err != nil {
+			log.Errorf("failed to encode feedback: %v", err)
+			writeError(w, requestID, "", err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Content-Disposition", `attachment; filename="feedback.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (h *Handler) listFeedback(w http.ResponseWriter, r *http.Request, requestID string) ([]feedback.Feedback, bool) {
+	if !h.isAdmin(w, r, requestID) {
+		return nil, false
+	}
+
+	query := r.URL.Query()
+	filter := feedback.Filter{
+		User:  query.Get("user"),
+		Model: query.Get("model"),
+	}
+	if query.Get("from") != "" || query.Get("to") != "" {
+		dates, err := usage.ParseDateRange(query.Get("from"), query.Get("to"))
+		if err != nil {
+			writeError(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, err, "invalid date range"))
+			return nil, false
+		}
+		filter.From, filter.To = dates.From, dates.To
+	}
+
+	list, err := h.FeedbackStore.List(filter)
+	if err != nil {
+		log.Errorf("failed to list feedback: %v", err)
+		writeError(w, requestID, "", err)
+		return nil, false
+	}
+	return list, true
+}
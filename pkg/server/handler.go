@@ -3,6 +3,13 @@ package server
 import (
 	"github.com/gorilla/sessions"
 	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/audit"
+	"github.com/openshift/wisdom/pkg/conversation"
+	"github.com/openshift/wisdom/pkg/feedback"
+	"github.com/openshift/wisdom/pkg/model"
+	"github.com/openshift/wisdom/pkg/quota"
+	"github.com/openshift/wisdom/pkg/ratelimit"
+	"github.com/openshift/wisdom/pkg/usage"
 	"golang.org/x/oauth2"
 )
 
@@ -10,12 +17,32 @@ type Handler struct {
 	DefaultModel    string
 	DefaultProvider string
 	Models          map[string]api.Model
-	ClientID        string
-	ClientSecret    string
+	// Fallbacks maps a provider/modelId to the models to try when it fails
+	Fallbacks    map[string][]string
+	ClientID     string
+	ClientSecret string
 	//SessionAuthKey       string
 	//SessionEncryptionKey string
 	AuthConfig         oauth2.Config
 	CookieStore        *sessions.CookieStore
 	TokenEncryptionKey []byte
 	AllowedUsers       map[string]bool
+	AdminUsers         map[string]bool
+	// UsageLedger records each user's model usage, it is nil when usage is not recorded
+	UsageLedger *usage.Ledger
+	// RateLimiter limits each user's inference requests, it is nil when requests are not limited
+	RateLimiter *ratelimit.Limiter
+	// Quotas tracks each user's tokens against their quotas, it is nil when tokens are not limited
+	Quotas *quota.Tracker
+	// Cache holds previous responses, it is nil when responses are not cached
+	Cache model.ResponseCache
+	// Coalescer shares invocations between concurrent identical requests
+	Coalescer *model.Coalescer
+	// AuditLog records each inference exchange, it is nil when exchanges are not logged
+	AuditLog *audit.Logger
+	// FeedbackStore stores feedback on the Responses recently served
+	FeedbackStore feedback.Store
+	Responses     *feedback.Responses
+	// Conversations holds each user's conversation history, it is nil when conversations are disabled
+	Conversations *conversation.Store
 }
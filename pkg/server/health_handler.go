@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/model"
+)
+
+type HealthStatus struct {
+	Status string                        `json:"status"`
+	Models map[string]model.BreakerState `json:"models"`
+}
+
+// HealthHandler reports the circuit breaker state of each model.  The server is reported
+// as unavailable while the default model's circuit breaker is open.
+func (h *Handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	health := HealthStatus{
+		Status: "ok",
+		Models: model.BreakerStates(h.Models),
+	}
+	statusCode := http.StatusOK
+	if health.Models[h.DefaultProvider+"/"+h.DefaultModel] == model.BreakerOpen {
+		health.Status = "unavailable"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(health); err != nil {
+		log.Errorf("failed to encode health status: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/json")
+	w.WriteHeader(statusCode)
+	w.Write(buf.Bytes())
+}
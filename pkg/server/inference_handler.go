@@ -2,19 +2,32 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/audit"
+	"github.com/openshift/wisdom/pkg/feedback"
+	"github.com/openshift/wisdom/pkg/metrics"
 	"github.com/openshift/wisdom/pkg/model"
+	"github.com/openshift/wisdom/pkg/tracing"
 )
 
 func (h *Handler) CORSHandler(w http.ResponseWriter, r *http.Request) {
 	http.Header.Add(w.Header(), "Access-Control-Allow-Origin", "*")
-	http.Header.Add(w.Header(), "Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	http.Header.Add(w.Header(), "Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 	http.Header.Add(w.Header(), "Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 }
@@ -23,15 +36,186 @@ func (h *Handler) InferHandler(w http.ResponseWriter, r *http.Request) {
 
 	http.Header.Add(w.Header(), "Access-Control-Allow-Origin", "*")
 
-	if !h.hasValidBearerToken(r) {
-		http.Error(w, "No valid bearer token found", http.StatusUnauthorized)
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+	start := time.Now()
+
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "InferHandler", trace.SpanKindServer)
+	defer span.End()
+	span.SetAttributes(attribute.String("request.id", requestID))
+
+	payload, _, username, ok := h.parseInferRequest(w, r, requestID)
+	if !ok {
+		return
+	}
+	h.loadConversation(username, &payload)
+
+	log.Debugf("Using provider/model %s/%s for prompt:\n%s\n", payload.Provider, payload.ModelId, payload.Prompt)
+
+	key := payload.Provider + "/" + payload.ModelId
+	chain := append([]string{key}, h.Fallbacks[key]...)
+	response, shared, err := h.Coalescer.Do(ctx, model.CoalesceKey(username, payload), func(ctx context.Context) (api.ModelResponse, error) {
+		return model.InvokeModelChain(ctx, payload, h.Models, chain, h.Cache, h.admitQuota(username))
+	})
+	response.Shared = shared
+	if err == nil {
+		h.saveTurn(username, payload, &response)
+	}
+	span.SetAttributes(attribute.String("model", response.Model), attribute.Bool("shared", shared))
+	tracing.SetError(span, err)
+	observeRequest(key, response, start, err)
+	h.recordUsage(username, response, err)
+	h.rememberResponse(requestID, username, response, err)
+	h.audit(requestID, username, payload, response, start, err, false)
+	if err != nil {
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			log.Debugf("client disconnected, abandoned model invocation: %v", err)
+			return
+		}
+		log.Errorf("failed to invoke model: %v", err)
+		writeError(w, requestID, response.Model, err)
+		return
+	}
+	response.RequestID = requestID
+
+	buf := bytes.Buffer{}
+	err = json.NewEncoder(&buf).Encode(response)
+	if err != nil {
+		log.Errorf("failed to encode response: %v", err)
+		writeError(w, requestID, response.Model, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// InferStreamHandler is the server-sent-events variant of InferHandler.  Output tokens are
+// sent as "token" events as the model generates them, followed by a single "result" event
+// containing the filtered response, or an "error" event containing an api.ErrorResponse if
+// invocation or filtering failed.
+func (h *Handler) InferStreamHandler(w http.ResponseWriter, r *http.Request) {
+
+	http.Header.Add(w.Header(), "Access-Control-Allow-Origin", "*")
+
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeInternal, nil, "streaming is not supported"))
+		return
+	}
+
+	start := time.Now()
+
+	ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "InferStreamHandler", trace.SpanKindServer)
+	defer span.End()
+	span.SetAttributes(attribute.String("request.id", requestID))
+
+	payload, m, username, ok := h.parseInferRequest(w, r, requestID)
+	if !ok {
 		return
 	}
+	h.loadConversation(username, &payload)
+
+	log.Debugf("Streaming from provider/model %s/%s for prompt:\n%s\n", payload.Provider, payload.ModelId, payload.Prompt)
+
+	// The event stream is started by the first token, so requests failing before the model
+	// generates any output, such as those over quota, receive an error status.
+	started := false
+	startStream := func() {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	// Tokens from a failed model cannot be retracted once sent, so fallback models are not
+	// used when streaming.  Streamed responses are not cached or shared.
+	response, err := model.InvokeModelStream(ctx, payload, m, h.admitQuota(username), func(token string) error {
+		if !started {
+			startStream()
+		}
+		if err := writeEvent(w, "token", streamToken{Token: token}); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	response.Model = payload.Provider + "/" + payload.ModelId
+	response.RequestID = requestID
+	if err == nil {
+		h.saveTurn(username, payload, &response)
+	}
+	span.SetAttributes(attribute.String("model", response.Model))
+	tracing.SetError(span, err)
+	observeRequest(response.Model, response, start, err)
+	h.recordUsage(username, response, err)
+	h.rememberResponse(requestID, username, response, err)
+	h.audit(requestID, username, payload, response, start, err, true)
+	if err != nil {
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			log.Debugf("client disconnected, abandoned model invocation: %v", err)
+			return
+		}
+		log.Debugf("model invocation returning error: %v", err)
+		if !started {
+			writeError(w, requestID, response.Model, err)
+			return
+		}
+		_, body := errorResponse(requestID, response.Model, err)
+		writeEvent(w, "error", body)
+	} else {
+		if !started {
+			startStream()
+		}
+		writeEvent(w, "result", response)
+	}
+	flusher.Flush()
+}
+
+type streamToken struct {
+	Token string `json:"token"`
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, buf)
+	return err
+}
+
+// parseInferRequest authorizes the request and decodes the model input, writing an error
+// response and returning false if the request cannot be served.  The authorized user's
+// name is returned with the input.
+func (h *Handler) parseInferRequest(w http.ResponseWriter, r *http.Request, requestID string) (api.ModelInput, api.Model, string, bool) {
 	var payload api.ModelInput
+
+	username, ok := h.hasValidBearerToken(r)
+	if !ok {
+		rejectRequest(w, requestID, "", api.NewError(api.ErrorCodeAuthFailed, nil, "no valid bearer token found"))
+		return payload, nil, "", false
+	}
+	if !h.allowRequest(w, username) {
+		rejectRequest(w, requestID, "", api.NewError(api.ErrorCodeRateLimited, nil, "rate limit exceeded for user %s", username))
+		return payload, nil, "", false
+	}
 	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
+		rejectRequest(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, err, "invalid request payload"))
+		return payload, nil, "", false
+	}
+
+	if payload.Parameters != nil {
+		if err := payload.Parameters.Validate(); err != nil {
+			rejectRequest(w, requestID, "", err)
+			return payload, nil, "", false
+		}
 	}
 
 	if payload.Provider == "" {
@@ -42,52 +226,228 @@ func (h *Handler) InferHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	m, found := h.Models[payload.Provider+"/"+payload.ModelId]
 	if !found {
-		http.Error(w, fmt.Sprintf("Invalid provider/model: %s|%s", payload.Provider, payload.ModelId), http.StatusBadRequest)
-		return
+		rejectRequest(w, requestID, "", api.NewError(api.ErrorCodeBadRequest, nil, "invalid provider/model: %s|%s", payload.Provider, payload.ModelId))
+		return payload, nil, "", false
 	}
+	return payload, m, username, true
+}
 
-	log.Debugf("Using provider/model %s/%s for prompt:\n%s\n", payload.Provider, payload.ModelId, payload.Prompt)
+// admitQuota returns the check of the user's quotas made before each model is invoked, so
+// fallback models over quota are skipped.  The completion's size is unknown until the model
+// has responded, so only the prompt, as built by the input filters, is checked.
+func (h *Handler) admitQuota(username string) model.AdmitFunc {
+	if h.Quotas == nil {
+		return nil
+	}
+	return func(modelName string, input api.ModelInput) error {
+		if err := h.Quotas.Check(username, modelName, api.EstimateInputTokens(input)); err != nil {
+			log.Debugf("user %s quota check for %s failed: %v", username, modelName, err)
+			return err
+		}
+		return nil
+	}
+}
 
-	response, err := model.InvokeModel(payload, m)
-	if err != nil {
-		log.Errorf("failed to invoke model: %v", err)
-		http.Error(w, "Failed to invoke model", http.StatusInternalServerError)
+// rejectRequest writes the error response for a request refused before invoking a model.
+func rejectRequest(w http.ResponseWriter, requestID, modelName string, err error) {
+	metrics.RejectedRequests.WithLabelValues(string(api.ErrorCodeOf(err))).Inc()
+	writeError(w, requestID, modelName, err)
+}
+
+// observeRequest records the result and duration of an inference request for the model
+// which served it, or the requested model if none did.
+func observeRequest(requested string, response api.ModelResponse, start time.Time, err error) {
+	name := response.Model
+	if name == "" {
+		name = requested
+	}
+	code := "ok"
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = "canceled"
+	case err != nil:
+		code = string(api.ErrorCodeOf(err))
+	}
+	metrics.Requests.WithLabelValues(name, code).Inc()
+	metrics.RequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// loadConversation assigns a new conversation id to the input if it has none, otherwise it
+// loads the history of the user's conversation.  An unknown conversation id starts a new
+// conversation with that id.
+func (h *Handler) loadConversation(username string, payload *api.ModelInput) {
+	if h.Conversations == nil {
+		return
+	}
+	if payload.ConversationID == "" {
+		payload.ConversationID = newRequestID()
 		return
 	}
+	if conversation, found := h.Conversations.Get(username, payload.ConversationID); found {
+		payload.History = conversation.Messages
+	}
+}
 
-	buf := bytes.Buffer{}
-	err = json.NewEncoder(&buf).Encode(response)
+// saveTurn adds the prompt and response to the user's conversation.
+func (h *Handler) saveTurn(username string, payload api.ModelInput, response *api.ModelResponse) {
+	if h.Conversations == nil {
+		return
+	}
+	response.ConversationID = payload.ConversationID
+	err := h.Conversations.Append(username, payload.ConversationID,
+		api.Message{Role: api.RoleUser, Content: payload.Prompt},
+		api.Message{Role: api.RoleAssistant, Content: response.Output},
+	)
 	if err != nil {
-		w.WriteHeader(http.StatusExpectationFailed)
-		w.Write([]byte(err.Error()))
+		log.Errorf("failed to save conversation %s: %v", payload.ConversationID, err)
+	}
+}
+
+// rememberResponse records the successful response so feedback can be given on it.
+func (h *Handler) rememberResponse(requestID, username string, response api.ModelResponse, err error) {
+	if err != nil || h.Responses == nil {
 		return
 	}
+	h.Responses.Add(requestID, feedback.Response{
+		User:           username,
+		Model:          response.Model,
+		ConversationID: response.ConversationID,
+		Time:           time.Now(),
+	})
+}
 
-	w.Header().Set("Content-Type", "text/json")
-	if err != nil || (response.Error != "") {
-		log.Debugf("model invocation returning error: %v", err)
-		w.WriteHeader(http.StatusExpectationFailed)
-	} else {
-		w.WriteHeader(http.StatusOK)
+// audit adds the exchange to the audit log, if one is configured.
+func (h *Handler) audit(requestID, username string, input api.ModelInput, response api.ModelResponse, start time.Time, err error, streamed bool) {
+	if h.AuditLog == nil {
+		return
+	}
+	record := audit.NewRecord(requestID, username, input, response, time.Since(start), err)
+	record.Streamed = streamed
+	if err := h.AuditLog.Log(record); err != nil {
+		log.Errorf("failed to write audit log: %v", err)
 	}
-	w.Write(buf.Bytes())
 }
 
-/*
-func (h *Handler) FeedbackHandler(w http.ResponseWriter, r *http.Request) {
-	var payload api.FeedbackPayload
-	err := json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+// allowRequest applies the rate limit to the user's request, setting the X-RateLimit-*
+// headers and, if the request is refused, the Retry-After header.
+func (h *Handler) allowRequest(w http.ResponseWriter, username string) bool {
+	if h.RateLimiter == nil {
+		return true
+	}
+	result := h.RateLimiter.Allow(username)
+	if result.Limit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	}
+	if !result.Allowed {
+		log.Debugf("user %s exceeded the rate limit, retry after %v", username, result.RetryAfter)
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	}
+	return result.Allowed
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// recordUsage adds each model invoked for the request to the usage ledger and debits the
+// user's quota.  Failed invocations, including those of fallback models which were tried
+// first, are recorded with whatever usage the model reported.  Cached responses are recorded
+// without usage, as are shared responses whose usage is recorded for the request which
+// invoked the models.
+func (h *Handler) recordUsage(username string, response api.ModelResponse, err error) {
+	if response.Model == "" {
+		return
+	}
+	for _, failure := range response.FailedModels {
+		if failure.Code == api.ErrorCodeQuotaExceeded {
+			// the model was not invoked
+			continue
+		}
+		usage := failure.Usage
+		if response.Shared {
+			usage = api.Usage{}
+		}
+		h.recordInvocation(username, failure.Model, usage, true)
+	}
+	if api.ErrorCodeOf(err) == api.ErrorCodeQuotaExceeded {
+		return
+	}
+	usage := response.Usage
+	if response.Cached || response.Shared {
+		usage = api.Usage{}
+	}
+	h.recordInvocation(username, response.Model, usage, err != nil)
+}
+
+func (h *Handler) recordInvocation(username, modelName string, usage api.Usage, failed bool) {
+	if h.Quotas != nil {
+		h.Quotas.Debit(username, modelName, usage.TotalTokens, time.Now())
+	}
+	if h.UsageLedger == nil {
 		return
 	}
+	if err := h.UsageLedger.Record(username, modelName, usage, failed); err != nil {
+		log.Errorf("failed to record usage: %v", err)
+	}
+}
 
-	// Handle the feedback request here
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Errorf("failed to generate request id: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
 
-	response := "Feedback received."
+// errorResponse returns the http status code and body describing err.
+func errorResponse(requestID, modelName string, err error) (int, api.ErrorResponse) {
+	detail := api.ErrorDetail{
+		Code:      api.ErrorCodeInternal,
+		Message:   err.Error(),
+		RequestID: requestID,
+		Model:     modelName,
+	}
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		detail.Code = apiErr.Code
+		detail.Filter = apiErr.Filter
+	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(response))
+	status := http.StatusInternalServerError
+	switch detail.Code {
+	case api.ErrorCodeAuthFailed:
+		status = http.StatusUnauthorized
+	case api.ErrorCodeForbidden:
+		status = http.StatusForbidden
+	case api.ErrorCodeNotFound:
+		status = http.StatusNotFound
+	case api.ErrorCodeRateLimited, api.ErrorCodeQuotaExceeded:
+		status = http.StatusTooManyRequests
+	case api.ErrorCodeTimeout:
+		status = http.StatusGatewayTimeout
+	case api.ErrorCodeBadRequest:
+		status = http.StatusBadRequest
+	case api.ErrorCodeUpstreamUnavailable:
+		status = http.StatusBadGateway
+	case api.ErrorCodeFilterRejected:
+		status = http.StatusUnprocessableEntity
+	case api.ErrorCodeModelUnavailable:
+		status = http.StatusServiceUnavailable
+	}
+	return status, api.ErrorResponse{Error: detail}
+}
+
+func writeError(w http.ResponseWriter, requestID, modelName string, err error) {
+	status, body := errorResponse(requestID, modelName, err)
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		log.Errorf("failed to encode error response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
 }
-*/
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/quota"
+)
+
+// QuotaHandler reports the state of the requesting user's token quotas.
+func (h *Handler) QuotaHandler(w http.ResponseWriter, r *http.Request) {
+	http.Header.Add(w.Header(), "Access-Control-Allow-Origin", "*")
+
+	requestID := newRequestID()
+	w.Header().Set("X-Request-ID", requestID)
+
+	username, ok := h.hasValidBearerToken(r)
+	if !ok {
+		writeError(w, requestID, "", api.NewError(api.ErrorCodeAuthFailed, nil, "no valid bearer token found"))
+		return
+	}
+
+	status := quota.Status{User: username}
+	if h.Quotas != nil {
+		status = h.Quotas.Status(username)
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(status); err != nil {
+		log.Errorf("failed to encode quota status: %v", err)
+		writeError(w, requestID, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
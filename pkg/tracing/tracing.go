@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/openshift/wisdom"
+
+func init() {
+	// incoming trace context is propagated to the model providers even when spans are not
+	// exported
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// Setup installs a tracer provider batching spans to exporter.  The returned function
+// flushes any buffered spans and shuts the provider down, it must be called before exit.
+func Setup(exporter sdktrace.SpanExporter, serviceName string) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		// honor the sampling decision of the caller, sampling everything else
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// Start starts a span as a child of the current span of ctx, returning a context in which
+// it is the current span.  Spans are not recorded until Setup has been called.
+func Start(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// SetError marks the span as failed with err, if err is not nil.
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Extract returns ctx with the remote parent span from the W3C traceparent and tracestate
+// headers, if they are present and valid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject sets the W3C traceparent and tracestate headers for the current span of ctx.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transport traces each request sent with base and propagates the trace context to the
+// server with the W3C trace context headers.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), "HTTP "+req.Method, trace.SpanKindClient)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path),
+	)
+
+	if span.SpanContext().IsValid() {
+		// a RoundTripper must not modify the caller's request
+		req = req.Clone(ctx)
+		Inject(ctx, req.Header)
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		SetError(span, err)
+		return resp, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		SetError(span, fmt.Errorf("status: %s", resp.Status))
+	}
+	return resp, nil
+}
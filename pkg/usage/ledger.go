@@ -0,0 +1,180 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+	"github.com/openshift/wisdom/pkg/jsonl"
+)
+
+// Record is a single model invocation in the ledger.
+type Record struct {
+	Time             time.Time `json:"time"`
+	User             string    `json:"user"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	TotalTokens      int       `json:"totalTokens"`
+	Cost             float64   `json:"cost"`
+	Failed           bool      `json:"failed,omitempty"`
+}
+
+// Summary is the usage of a model by a user over a date range.
+type Summary struct {
+	User             string  `json:"user"`
+	Model            string  `json:"model"`
+	Requests         int     `json:"requests"`
+	FailedRequests   int     `json:"failedRequests"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Filter selects the records included in a report.  Empty fields match everything.
+type Filter struct {
+	From  time.Time
+	To    time.Time
+	User  string
+	Model string
+}
+
+func (f Filter) matches(r Record) bool {
+	if !f.From.IsZero() && r.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.Time.Before(f.To) {
+		return false
+	}
+	if f.User != "" && r.User != f.User {
+		return false
+	}
+	if f.Model != "" && r.Model != f.Model {
+		return false
+	}
+	return true
+}
+
+// Ledger appends usage records to a JSON lines file.
+type Ledger struct {
+	path    string
+	pricing map[string]api.PricingConfig
+	now     func() time.Time
+	file    *jsonl.File
+}
+
+// NewLedger opens, creating if needed, the ledger at path.  pricing maps a provider/modelId
+// to its token prices and may be nil when only reading the ledger.
+func NewLedger(path string, pricing map[string]api.PricingConfig) (*Ledger, error) {
+	file, err := jsonl.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage ledger %s: %v", path, err)
+	}
+	return &Ledger{
+		path:    path,
+		pricing: pricing,
+		now:     time.Now,
+		file:    file,
+	}, nil
+}
+
+// Record adds an invocation of model on behalf of user to the ledger.
+func (l *Ledger) Record(user, model string, usage api.Usage, failed bool) error {
+	record := Record{
+		Time:             l.now().UTC(),
+		User:             user,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Cost:             l.pricing[model].Cost(usage),
+		Failed:           failed,
+	}
+	return l.file.Append(record)
+}
+
+// Scan calls fn with each record matching filter, in the order they were recorded.
+func (l *Ledger) Scan(filter Filter, fn func(Record)) error {
+	return jsonl.Read(l.path, func(line int, data []byte) error {
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("invalid usage record on line %d of %s: %v", line, l.path, err)
+		}
+		if filter.matches(record) {
+			fn(record)
+		}
+		return nil
+	})
+}
+
+// Report summarizes the records matching filter by user and model.
+func (l *Ledger) Report(filter Filter) ([]Summary, error) {
+	summaries := map[[2]string]*Summary{}
+	err := l.Scan(filter, func(record Record) {
+		key := [2]string{record.User, record.Model}
+		s, found := summaries[key]
+		if !found {
+			s = &Summary{User: record.User, Model: record.Model}
+			summaries[key] = s
+		}
+		s.Requests++
+		if record.Failed {
+			s.FailedRequests++
+		}
+		s.PromptTokens += record.PromptTokens
+		s.CompletionTokens += record.CompletionTokens
+		s.TotalTokens += record.TotalTokens
+		s.Cost += record.Cost
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := []Summary{}
+	for _, s := range summaries {
+		report = append(report, *s)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].User != report[j].User {
+			return report[i].User < report[j].User
+		}
+		return report[i].Model < report[j].Model
+	})
+	return report, nil
+}
+
+func (l *Ledger) Close() error {
+	return l.file.Close()
+}
+
+// ParseDateRange parses from and to dates of the form 2006-01-02 into a filter covering
+// both days in UTC.  An empty to defaults to today and an empty from defaults to the start
+// of to's month.
+func ParseDateRange(from, to string) (Filter, error) {
+	return parseDateRange(from, to, time.Now())
+}
+
+func parseDateRange(from, to string, now time.Time) (Filter, error) {
+	filter := Filter{}
+	last := now.UTC().Truncate(24 * time.Hour)
+	var err error
+	if to != "" {
+		if last, err = time.Parse("2006-01-02", to); err != nil {
+			return filter, fmt.Errorf("invalid to date %q: %v", to, err)
+		}
+	}
+	filter.To = last.AddDate(0, 0, 1)
+	filter.From = time.Date(last.Year(), last.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if from != "" {
+		if filter.From, err = time.Parse("2006-01-02", from); err != nil {
+			return filter, fmt.Errorf("invalid from date %q: %v", from, err)
+		}
+	}
+	if !filter.To.After(filter.From) {
+		return filter, fmt.Errorf("to date must not be before from date")
+	}
+	return filter, nil
+}
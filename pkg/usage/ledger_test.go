@@ -0,0 +1,168 @@
+package usage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openshift/wisdom/pkg/api"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseDateRange(t *testing.T) {
+	now := time.Date(2023, 3, 15, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		want    Filter
+		wantErr bool
+	}{
+		{
+			name: "empty range is the month to date",
+			want: Filter{From: date(2023, 3, 1), To: date(2023, 3, 16)},
+		},
+		{
+			name: "range open at the end runs to today",
+			from: "2023-02-10",
+			want: Filter{From: date(2023, 2, 10), To: date(2023, 3, 16)},
+		},
+		{
+			name: "range open at the start runs from the start of the month",
+			to:   "2023-02-10",
+			want: Filter{From: date(2023, 2, 1), To: date(2023, 2, 11)},
+		},
+		{
+			name: "range includes both days",
+			from: "2022-12-31",
+			to:   "2023-01-02",
+			want: Filter{From: date(2022, 12, 31), To: date(2023, 1, 3)},
+		},
+		{
+			name: "range of a single day",
+			from: "2023-01-02",
+			to:   "2023-01-02",
+			want: Filter{From: date(2023, 1, 2), To: date(2023, 1, 3)},
+		},
+		{
+			name:    "from after to",
+			from:    "2023-01-03",
+			to:      "2023-01-02",
+			wantErr: true,
+		},
+		{
+			name:    "from after today",
+			from:    "2023-03-16",
+			wantErr: true,
+		},
+		{
+			name:    "invalid from",
+			from:    "2023-13-01",
+			wantErr: true,
+		},
+		{
+			name:    "invalid to",
+			to:      "15/03/2023",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDateRange(tt.from, tt.to, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got filter %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	pricing := map[string]api.PricingConfig{
+		"openai/gpt-3.5-turbo": {PromptTokens: 1.5, CompletionTokens: 2},
+	}
+	records := []struct {
+		time   time.Time
+		user   string
+		model  string
+		usage  api.Usage
+		failed bool
+	}{
+		{date(2023, 3, 14), "alice", "openai/gpt-3.5-turbo", api.Usage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500}, false},
+		{date(2023, 3, 15), "alice", "openai/gpt-3.5-turbo", api.Usage{PromptTokens: 2000, CompletionTokens: 1000, TotalTokens: 3000}, true},
+		{date(2023, 3, 15), "alice", "ollama/llama2", api.Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150}, false},
+		{date(2023, 2, 28), "bob", "openai/gpt-3.5-turbo", api.Usage{PromptTokens: 1000, TotalTokens: 1000}, false},
+	}
+
+	ledger, err := NewLedger(filepath.Join(t.TempDir(), "usage.jsonl"), pricing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ledger.Close()
+	for _, r := range records {
+		ledger.now = func() time.Time { return r.time }
+		if err := ledger.Record(r.user, r.model, r.usage, r.failed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliceGPT := Summary{User: "alice", Model: "openai/gpt-3.5-turbo", Requests: 2, FailedRequests: 1, PromptTokens: 3000, CompletionTokens: 1500, TotalTokens: 4500, Cost: 7.5}
+	aliceLlama := Summary{User: "alice", Model: "ollama/llama2", Requests: 1, PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150}
+	bobGPT := Summary{User: "bob", Model: "openai/gpt-3.5-turbo", Requests: 1, PromptTokens: 1000, TotalTokens: 1000, Cost: 1.5}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []Summary
+	}{
+		{
+			name:   "all records, with the cost of priced models",
+			filter: Filter{},
+			want:   []Summary{aliceLlama, aliceGPT, bobGPT},
+		},
+		{
+			name:   "date range",
+			filter: Filter{From: date(2023, 3, 1), To: date(2023, 3, 16)},
+			want:   []Summary{aliceLlama, aliceGPT},
+		},
+		{
+			name:   "date range excludes the end",
+			filter: Filter{From: date(2023, 2, 28), To: date(2023, 3, 15)},
+			want: []Summary{
+				{User: "alice", Model: "openai/gpt-3.5-turbo", Requests: 1, PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500, Cost: 2.5},
+				bobGPT,
+			},
+		},
+		{
+			name:   "user",
+			filter: Filter{User: "bob"},
+			want:   []Summary{bobGPT},
+		},
+		{
+			name:   "model",
+			filter: Filter{Model: "ollama/llama2"},
+			want:   []Summary{aliceLlama},
+		},
+		{
+			name:   "no matching records",
+			filter: Filter{User: "carol"},
+			want:   []Summary{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ledger.Report(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got report %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}